@@ -0,0 +1,122 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/cluster"
+	"github.com/drone-runners/drone-runner-kube/engine/podwatcher"
+	"github.com/drone-runners/drone-runner-kube/internal/kube"
+
+	"github.com/drone/runner-go/pipeline/runtime"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// newEngine returns the pipeline engine of a cluster, and the kubernetes
+// engine that runs the pipeline pods, which knows the running pipelines.
+func newEngine(ctx context.Context, config Config, kubeClient kubernetes.Interface, kubeConfig *rest.Config) (*engine.Kubernetes, runtime.Engine) {
+	var podInformer *podwatcher.PodInformer
+	if config.Engine.PodInformer {
+		namespace := config.Namespace.Default
+		if config.Engine.PodInformerAllNamespaces {
+			namespace = ""
+		}
+
+		podInformer = podwatcher.NewPodInformer(kubeClient, namespace)
+		podInformer.Start(ctx)
+	}
+
+	kubeEngine := engine.New(kubeClient, kubeConfig, podInformer,
+		time.Duration(config.Engine.ContainerStartTimeout)*time.Second,
+		time.Duration(config.Engine.PodScheduleTimeout)*time.Second,
+		time.Duration(config.Engine.LogStreamTimeout)*time.Second,
+		config.Engine.NativeSidecars)
+
+	// the pod per step engine runs the step pods with the kubernetes engine.
+	if config.Engine.Backend == backendPodPerStep {
+		return kubeEngine, engine.NewPodPerStep(kubeEngine, engine.WorkspaceClaim{
+			StorageClass: config.Workspace.StorageClass,
+			Size:         config.Workspace.Parsed,
+			AccessMode:   v1.PersistentVolumeAccessMode(config.Workspace.AccessMode),
+		})
+	}
+
+	return kubeEngine, kubeEngine
+}
+
+// newReaper returns the reaper of a cluster.
+func newReaper(config Config, kubeClient kubernetes.Interface, kubeEngine *engine.Kubernetes) *reaper {
+	return &reaper{
+		client:     kubeClient,
+		runner:     config.Runner.Name,
+		instance:   config.Runner.Instance,
+		namespaces: config.Reaper.Namespaces,
+		interval:   config.Reaper.Interval,
+		minAge:     config.Reaper.MinAge,
+		dryRun:     config.Reaper.DryRun,
+		isActive:   kubeEngine.IsActive,
+	}
+}
+
+// newRouter returns the engine that dispatches the pipelines to the configured clusters,
+// and a reaper for each cluster. Every cluster has its own kubernetes client and engine.
+func newRouter(ctx context.Context, config Config) (*cluster.Router, []*reaper, error) {
+	var clusters []*cluster.Cluster
+	var reapers []*reaper
+
+	for _, c := range config.Clusters.Parsed {
+		var kubeClient kubernetes.Interface
+		var kubeConfig *rest.Config
+		var err error
+
+		if c.Kubeconfig == "" && c.Context == "" {
+			kubeClient, kubeConfig, err = kube.NewInCluster((*kube.ClientConfig)(&config.KubernetesClient))
+		} else {
+			kubeClient, kubeConfig, err = kube.NewFromContext((*kube.ClientConfig)(&config.KubernetesClient), c.Kubeconfig, c.Context)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot load the kubernetes client of cluster %q: %w", c.Name, err)
+		}
+
+		// the health check of an unreachable cluster fails after the timeout,
+		// instead of the default timeout of the kubernetes client, which is none.
+		healthConfig := rest.CopyConfig(kubeConfig)
+		healthConfig.Timeout = config.Clusters.HealthTimeout
+
+		healthClient, err := kubernetes.NewForConfig(healthConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot create the health check client of cluster %q: %w", c.Name, err)
+		}
+
+		kubeEngine, pipelineEngine := newEngine(ctx, config, kubeClient, kubeConfig)
+
+		clusters = append(clusters, &cluster.Cluster{
+			Name:   c.Name,
+			Arch:   c.Arch,
+			Client: healthClient,
+			Engine: pipelineEngine,
+		})
+		reapers = append(reapers, newReaper(config, kubeClient, kubeEngine))
+
+		logrus.WithField("cluster", c.Name).
+			WithField("kubeconfig", c.Kubeconfig).
+			WithField("context", c.Context).
+			WithField("arch", c.Arch).
+			Infoln("loaded the kubernetes cluster")
+	}
+
+	router := cluster.New(clusters...)
+	router.Start(ctx, config.Clusters.HealthInterval)
+
+	return router, reapers, nil
+}
@@ -8,13 +8,23 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
+	"github.com/drone-runners/drone-runner-kube/engine/mesh"
 	"github.com/drone-runners/drone-runner-kube/engine/policy"
 
 	"github.com/buildkite/yaml"
 	"github.com/docker/go-units"
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// the engine backends, see Config.Engine.Backend.
+const (
+	backendPod        = "pod"
+	backendPodPerStep = "pod-per-step"
 )
 
 // Config stores the system configuration.
@@ -48,6 +58,7 @@ type Config struct {
 
 	Runner struct {
 		Name       string            `envconfig:"DRONE_RUNNER_NAME"`
+		Instance   string            `envconfig:"DRONE_RUNNER_INSTANCE"`
 		Capacity   int               `envconfig:"DRONE_RUNNER_CAPACITY" default:"100"`
 		Procs      int64             `envconfig:"DRONE_RUNNER_MAX_PROCS"`
 		Environ    map[string]string `envconfig:"DRONE_RUNNER_ENVIRON"`
@@ -86,6 +97,7 @@ type Config struct {
 		Endpoint   string `envconfig:"DRONE_SECRET_PLUGIN_ENDPOINT"`
 		Token      string `envconfig:"DRONE_SECRET_PLUGIN_TOKEN"`
 		SkipVerify bool   `envconfig:"DRONE_SECRET_PLUGIN_SKIP_VERIFY"`
+		PerStep    bool   `envconfig:"DRONE_SECRET_PER_STEP"`
 	}
 
 	Netrc struct {
@@ -109,8 +121,10 @@ type Config struct {
 	}
 
 	Images struct {
-		Clone       string `envconfig:"DRONE_IMAGE_CLONE"`
-		Placeholder string `envconfig:"DRONE_IMAGE_PLACEHOLDER"`
+		Clone              string `envconfig:"DRONE_IMAGE_CLONE"`
+		Placeholder        string `envconfig:"DRONE_IMAGE_PLACEHOLDER"`
+		CloneWindows       string `envconfig:"DRONE_IMAGE_CLONE_WINDOWS"`
+		PlaceholderWindows string `envconfig:"DRONE_IMAGE_PLACEHOLDER_WINDOWS"`
 	}
 
 	ServiceAccount struct {
@@ -145,9 +159,75 @@ type Config struct {
 		ED25519 string `envconfig:"DRONE_TMATE_FINGERPRINT_ED25519"`
 	}
 
+	// DebugContainer enables debugging the failed steps of the builds run in debug mode with an ephemeral
+	// container, instead of tmate. The pod is kept alive until the debug session ends. The debug shell exits,
+	// and the session ends, if it gets no input for the idle timeout; the shell of the image must support TMOUT.
+	DebugContainer struct {
+		Enabled     bool          `envconfig:"DRONE_DEBUG_CONTAINER_ENABLED"`
+		Image       string        `envconfig:"DRONE_DEBUG_CONTAINER_IMAGE" default:"busybox:1"`
+		IdleTimeout time.Duration `envconfig:"DRONE_DEBUG_CONTAINER_IDLE_TIMEOUT" default:"30m"`
+	}
+
 	Engine struct {
-		ContainerStartTimeout      int `envconfig:"DRONE_ENGINE_CONTAINER_START_TIMEOUT" default:"480"`
-		ContainerTimeToWaitForLogs int `envconfig:"DRONE_ENGINE_CONTAINER_TIME_TO_WAIT_FOR_LOGS" default:"0"` // 0 means no delay, this is a hack to ensure logs are streamed if there is an issue with the container startuo. This is in seconds.
+		ContainerStartTimeout int `envconfig:"DRONE_ENGINE_CONTAINER_START_TIMEOUT" default:"480"`
+		PodScheduleTimeout    int `envconfig:"DRONE_ENGINE_POD_SCHEDULE_TIMEOUT" default:"300"`
+		LogStreamTimeout      int `envconfig:"DRONE_ENGINE_LOG_STREAM_TIMEOUT" default:"30"` // max time in seconds to wait for log streams to finish before the pod is deleted.
+
+		// PodInformer enables watching the pipeline pods in the default namespace, or in all namespaces,
+		// with a single shared informer instead of a separate watch per pod.
+		PodInformer              bool `envconfig:"DRONE_ENGINE_POD_INFORMER" default:"true"`
+		PodInformerAllNamespaces bool `envconfig:"DRONE_ENGINE_POD_INFORMER_ALL_NAMESPACES"`
+
+		// NativeSidecars enables running the pipeline services as native sidecar containers,
+		// if the cluster supports them (Kubernetes 1.29 and newer). Kubernetes restarts a native
+		// sidecar that exits, so a service that exits is reported with its last termination.
+		NativeSidecars bool `envconfig:"DRONE_ENGINE_NATIVE_SIDECARS"`
+
+		// Backend selects how the pipelines are run: "pod" runs all steps of a pipeline in a single pod,
+		// "pod-per-step" runs each step in its own pod, sharing the workspace through a persistent volume claim.
+		Backend string `envconfig:"DRONE_ENGINE_BACKEND" default:"pod"`
+	}
+
+	// Workspace configures the persistent volume claim holding the workspace of a pipeline,
+	// if each step runs in its own pod. With the ReadWriteOnce access mode, the step pods of
+	// a pipeline run on the same node.
+	Workspace struct {
+		StorageClass string            `envconfig:"DRONE_WORKSPACE_STORAGE_CLASS"`
+		Size         string            `envconfig:"DRONE_WORKSPACE_SIZE" default:"10Gi"`
+		AccessMode   string            `envconfig:"DRONE_WORKSPACE_ACCESS_MODE" default:"ReadWriteMany"`
+		Parsed       resource.Quantity `envconfig:"-"`
+	}
+
+	Reaper struct {
+		Enabled    bool          `envconfig:"DRONE_REAPER_ENABLED"`
+		Interval   time.Duration `envconfig:"DRONE_REAPER_INTERVAL" default:"10m"`
+		MinAge     time.Duration `envconfig:"DRONE_REAPER_MIN_AGE" default:"1h"`
+		DryRun     bool          `envconfig:"DRONE_REAPER_DRY_RUN"`
+		Namespaces []string      `envconfig:"DRONE_REAPER_NAMESPACES"`
+	}
+
+	Tracing struct {
+		Enabled     bool    `envconfig:"DRONE_TRACING_ENABLED"`
+		Endpoint    string  `envconfig:"DRONE_TRACING_ENDPOINT" default:"localhost:4318"` // host and port of the OTLP/HTTP collector.
+		Insecure    bool    `envconfig:"DRONE_TRACING_INSECURE"`
+		ServiceName string  `envconfig:"DRONE_TRACING_SERVICE_NAME" default:"drone-runner-kube"`
+		SampleRatio float64 `envconfig:"DRONE_TRACING_SAMPLE_RATIO" default:"1"`
+	}
+
+	ServiceMesh struct {
+		// Injection is "enabled" or "disabled" to annotate the pipeline pods so that a service mesh
+		// (Istio or Linkerd) does or doesn't inject its proxy. If empty, the pods aren't annotated.
+		Injection string `envconfig:"DRONE_SERVICE_MESH_INJECTION"`
+	}
+
+	// Clusters configures the Kubernetes clusters the pipelines are dispatched to. If no
+	// clusters are configured, the pipelines run in the cluster of DRONE_KUBERNETES_CONFIG,
+	// or in the cluster the runner is deployed in.
+	Clusters struct {
+		File           string          `envconfig:"DRONE_CLUSTERS_FILE"`
+		HealthInterval time.Duration   `envconfig:"DRONE_CLUSTERS_HEALTH_INTERVAL" default:"30s"`
+		HealthTimeout  time.Duration   `envconfig:"DRONE_CLUSTERS_HEALTH_TIMEOUT" default:"10s"`
+		Parsed         []ClusterConfig `envconfig:"-"`
 	}
 
 	KubernetesClient struct {
@@ -156,6 +236,21 @@ type Config struct {
 	}
 }
 
+// ClusterConfig configures a Kubernetes cluster the pipelines are dispatched to.
+type ClusterConfig struct {
+	// Name identifies the cluster. A pipeline selects it with the cluster key, or a policy with the cluster field.
+	Name string `yaml:"name"`
+
+	// Kubeconfig is the path to the kubeconfig file, and Context is the context used from it. If both are
+	// empty, the runner uses the in-cluster configuration, i.e. the cluster the runner is deployed in.
+	Kubeconfig string `yaml:"kubeconfig"`
+	Context    string `yaml:"context"`
+
+	// Arch lists the architectures of the cluster's nodes. The pipelines that don't select a cluster by name
+	// run in the first healthy cluster of their platform architecture. If empty, the cluster accepts any.
+	Arch []string `yaml:"arch"`
+}
+
 // legacy environment variables. the key is the legacy
 // variable name, and the value is the new variable name.
 var legacy = map[string]string{
@@ -181,6 +276,9 @@ func fromEnviron() (Config, error) {
 	if config.Runner.Name == "" {
 		config.Runner.Name, _ = os.Hostname()
 	}
+	if config.Runner.Instance == "" {
+		config.Runner.Instance, _ = os.Hostname()
+	}
 	if config.Dashboard.Password == "" {
 		config.Dashboard.Disabled = true
 	}
@@ -209,6 +307,67 @@ func fromEnviron() (Config, error) {
 		config.Namespace.Rules[k] = []string{v}
 	}
 
+	// if not specified, the reaper looks for orphaned resources
+	// in all namespaces the runner is configured to use.
+	if len(config.Reaper.Namespaces) == 0 {
+		config.Reaper.Namespaces = append(config.Reaper.Namespaces, config.Namespace.Default)
+		for namespace := range config.Namespace.Rules {
+			if namespace != config.Namespace.Default {
+				config.Reaper.Namespaces = append(config.Reaper.Namespaces, namespace)
+			}
+		}
+	}
+
+	switch config.ServiceMesh.Injection {
+	case mesh.InjectionDefault, mesh.InjectionEnabled, mesh.InjectionDisabled:
+	default:
+		return config, fmt.Errorf("invalid DRONE_SERVICE_MESH_INJECTION value %q, expected %q or %q",
+			config.ServiceMesh.Injection, mesh.InjectionEnabled, mesh.InjectionDisabled)
+	}
+
+	switch config.Engine.Backend {
+	case backendPod:
+	case backendPodPerStep:
+		config.Workspace.Parsed, err = resource.ParseQuantity(config.Workspace.Size)
+		if err != nil {
+			return config, fmt.Errorf("invalid DRONE_WORKSPACE_SIZE value %q: %w", config.Workspace.Size, err)
+		}
+
+		switch v1.PersistentVolumeAccessMode(config.Workspace.AccessMode) {
+		case v1.ReadWriteMany, v1.ReadWriteOnce:
+		default:
+			return config, fmt.Errorf("invalid DRONE_WORKSPACE_ACCESS_MODE value %q, expected %q or %q",
+				config.Workspace.AccessMode, v1.ReadWriteMany, v1.ReadWriteOnce)
+		}
+	default:
+		return config, fmt.Errorf("invalid DRONE_ENGINE_BACKEND value %q, expected %q or %q",
+			config.Engine.Backend, backendPod, backendPodPerStep)
+	}
+
+	// the clusters the pipelines are dispatched to are
+	// sourced from a separate file.
+	if file := config.Clusters.File; file != "" {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			return config, err
+		}
+		err = yaml.Unmarshal(out, &config.Clusters.Parsed)
+		if err != nil {
+			return config, err
+		}
+
+		names := map[string]bool{}
+		for _, cluster := range config.Clusters.Parsed {
+			if cluster.Name == "" {
+				return config, fmt.Errorf("missing cluster name in %s", file)
+			}
+			if names[cluster.Name] {
+				return config, fmt.Errorf("duplicate cluster name %q in %s", cluster.Name, file)
+			}
+			names[cluster.Name] = true
+		}
+	}
+
 	// environment variables can be sourced from a separate
 	// file. These variables are loaded and appended to the
 	// environment list.
@@ -6,6 +6,7 @@ package daemon
 
 import (
 	"context"
+	"net/http"
 	"time"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
@@ -31,10 +32,12 @@ import (
 	"github.com/drone/signal"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 // empty context.
@@ -67,6 +70,19 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		cancel()
 	})
 
+	if config.Tracing.Enabled {
+		shutdown, err := setupTracing(ctx, config)
+		if err != nil {
+			logrus.WithError(err).
+				Fatalln("cannot create the trace exporter")
+		}
+		defer shutdown(nocontext)
+
+		logrus.WithField("endpoint", config.Tracing.Endpoint).
+			WithField("sample-ratio", config.Tracing.SampleRatio).
+			Infoln("tracing enabled")
+	}
+
 	cli := client.New(
 		config.Client.Address,
 		config.Client.Secret,
@@ -83,31 +99,46 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		),
 	)
 
-	var kubeClient kubernetes.Interface
+	var pipelineEngine runtime.Engine
+	var reapers []*reaper
 
-	if path := config.Runner.Config; path != "" {
-		// if the configuration path is specified, we create
-		// the kubernetes client from the configuration file.
-		// This is used primarily for local out-of-cluster
-		// testing.
-		kubeClient, err = kube.NewFromConfig((*kube.ClientConfig)(&config.KubernetesClient), path)
+	if len(config.Clusters.Parsed) != 0 {
+		// if the clusters are configured, each pipeline is
+		// dispatched to the engine of the selected cluster.
+		pipelineEngine, reapers, err = newRouter(ctx, config)
 		if err != nil {
 			logrus.WithError(err).
-				Fatalln("cannot load the kubernetes client from config")
+				Fatalln("cannot load the kubernetes clusters")
 		}
 	} else {
-		// else, if no configuration is specified, we create
-		// the kubernetes client using the in-cluster
-		// configuration file.
-		kubeClient, err = kube.NewInCluster((*kube.ClientConfig)(&config.KubernetesClient))
-		if err != nil {
-			logrus.WithError(err).
-				Fatalln("cannot load the in-cluster kubernetes client")
+		var kubeClient kubernetes.Interface
+		var kubeConfig *rest.Config
+
+		if path := config.Runner.Config; path != "" {
+			// if the configuration path is specified, we create
+			// the kubernetes client from the configuration file.
+			// This is used primarily for local out-of-cluster
+			// testing.
+			kubeClient, kubeConfig, err = kube.NewFromConfig((*kube.ClientConfig)(&config.KubernetesClient), path)
+			if err != nil {
+				logrus.WithError(err).
+					Fatalln("cannot load the kubernetes client from config")
+			}
+		} else {
+			// else, if no configuration is specified, we create
+			// the kubernetes client using the in-cluster
+			// configuration file.
+			kubeClient, kubeConfig, err = kube.NewInCluster((*kube.ClientConfig)(&config.KubernetesClient))
+			if err != nil {
+				logrus.WithError(err).
+					Fatalln("cannot load the in-cluster kubernetes client")
+			}
 		}
-	}
 
-	kubeEngine := engine.New(kubeClient,
-		time.Duration(config.Engine.ContainerStartTimeout)*time.Second, time.Duration(config.Engine.ContainerTimeToWaitForLogs)*time.Second)
+		var kubeEngine *engine.Kubernetes
+		kubeEngine, pipelineEngine = newEngine(ctx, config, kubeClient, kubeConfig)
+		reapers = append(reapers, newReaper(config, kubeClient, kubeEngine))
+	}
 
 	remote := remote.New(cli)
 	tracer := history.New(remote)
@@ -128,17 +159,23 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 			config.Limit.Trusted,
 		),
 		Compiler: &compiler.Compiler{
-			Cloner:         config.Images.Clone,
-			Placeholder:    config.Images.Placeholder,
-			NetrcCloneOnly: config.Netrc.CloneOnly,
-			Volumes:        config.Runner.Volumes,
-			Namespace:      config.Namespace.Default,
-			Labels:         config.Labels.Default,
-			Annotations:    config.Annotations.Default,
-			ServiceAccount: config.ServiceAccount.Default,
-			NodeSelector:   config.NodeSelector.Default,
-			Privileged:     append(config.Runner.Privileged, compiler.Privileged...),
-			Policies:       config.Policy.Parsed,
+			Runner:             config.Runner.Name,
+			RunnerInstance:     config.Runner.Instance,
+			Cloner:             config.Images.Clone,
+			Placeholder:        config.Images.Placeholder,
+			ClonerWindows:      config.Images.CloneWindows,
+			PlaceholderWindows: config.Images.PlaceholderWindows,
+			NetrcCloneOnly:     config.Netrc.CloneOnly,
+			Volumes:            config.Runner.Volumes,
+			Namespace:          config.Namespace.Default,
+			Labels:             config.Labels.Default,
+			Annotations:        config.Annotations.Default,
+			ServiceAccount:     config.ServiceAccount.Default,
+			NodeSelector:       config.NodeSelector.Default,
+			Privileged:         append(config.Runner.Privileged, compiler.Privileged...),
+			Policies:           config.Policy.Parsed,
+			SecretPerStep:      config.Secret.PerStep,
+			SidecarInjection:   config.ServiceMesh.Injection,
 			Registry: registry.Combine(
 				registry.File(
 					config.Docker.Config,
@@ -189,12 +226,17 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 				RSA:     config.Tmate.RSA,
 				ED25519: config.Tmate.ED25519,
 			},
+			DebugContainer: compiler.DebugContainer{
+				Enabled:     config.DebugContainer.Enabled,
+				Image:       config.DebugContainer.Image,
+				IdleTimeout: config.DebugContainer.IdleTimeout,
+			},
 		},
 		Exec: runtime.NewExecer(
 			tracer,
 			remote,
 			upload,
-			kubeEngine,
+			pipelineEngine,
 			config.Runner.Procs,
 		).Exec,
 	}
@@ -204,7 +246,7 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		// of open requests when polling the queue. This is
 		// an experimental feature and requires further testing.
 		Client:   &client.SingleFlight{Client: cli},
-		Dispatch: runner.Run,
+		Dispatch: traceStage(runner.Run),
 		Filter: &client.Filter{
 			Kind:   resource.Kind,
 			Type:   resource.Type,
@@ -212,14 +254,19 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		},
 	}
 
+	// the prometheus metrics are served next to the dashboard.
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/", router.New(tracer, hook, router.Config{
+		Username: config.Dashboard.Username,
+		Password: config.Dashboard.Password,
+		Realm:    config.Dashboard.Realm,
+	}))
+
 	var g errgroup.Group
 	server := server.Server{
-		Addr: config.Server.Port,
-		Handler: router.New(tracer, hook, router.Config{
-			Username: config.Dashboard.Username,
-			Password: config.Dashboard.Password,
-			Realm:    config.Dashboard.Realm,
-		}),
+		Addr:    config.Server.Port,
+		Handler: mux,
 	}
 
 	logrus.WithField("addr", config.Server.Port).
@@ -229,6 +276,22 @@ func (c *daemonCommand) run(*kingpin.ParseContext) error {
 		return server.ListenAndServe(ctx)
 	})
 
+	if config.Reaper.Enabled {
+		logrus.WithField("namespaces", config.Reaper.Namespaces).
+			WithField("interval", config.Reaper.Interval).
+			WithField("min-age", config.Reaper.MinAge).
+			WithField("dry-run", config.Reaper.DryRun).
+			Infoln("starting the reaper")
+
+		for _, reaper := range reapers {
+			reaper := reaper
+			g.Go(func() error {
+				reaper.run(ctx)
+				return nil
+			})
+		}
+	}
+
 	// Ping the server and block until a successful connection
 	// to the server has been established.
 	for {
@@ -0,0 +1,160 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine/compiler"
+
+	"github.com/sirupsen/logrus"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// reaper periodically deletes kubernetes resources (pods, secrets, workspace
+// claims and namespaces) created by this runner that do not belong to any running
+// pipeline. Such resources are left behind if the runner process is
+// terminated while pipelines are running.
+//
+// Only the resources labeled with the runner instance are deleted, the replicas of
+// a runner don't know about each other's pipelines. A restarted runner finds the
+// resources it left behind only if its instance name is stable, for example the
+// hostname of a StatefulSet pod.
+type reaper struct {
+	client     kubernetes.Interface
+	runner     string
+	instance   string
+	namespaces []string
+	interval   time.Duration
+	minAge     time.Duration
+	dryRun     bool
+
+	// isActive returns true if the pod (identified by io.drone.name label)
+	// belongs to a pipeline that is currently running.
+	isActive func(name string) bool
+}
+
+// run executes the reaper until the context is done.
+func (r *reaper) run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.reap(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reap finds and deletes the orphaned resources.
+func (r *reaper) reap(ctx context.Context) {
+	opts := metav1.ListOptions{
+		LabelSelector: labels.Set{
+			"io.drone":                 "true",
+			"io.drone.runner.name":     compiler.LabelValue(r.runner),
+			"io.drone.runner.instance": compiler.LabelValue(r.instance),
+		}.String(),
+	}
+
+	for _, namespace := range r.namespaces {
+		log := logrus.WithField("namespace", namespace)
+
+		pods, err := r.client.CoreV1().Pods(namespace).List(ctx, opts)
+		if err != nil {
+			log.WithError(err).Warnln("reaper: cannot list pods")
+		} else {
+			for _, pod := range pods.Items {
+				r.delete(log.WithField("pod", pod.Name), "pod", pod.ObjectMeta, func() error {
+					return r.client.CoreV1().Pods(namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+				})
+			}
+		}
+
+		secrets, err := r.client.CoreV1().Secrets(namespace).List(ctx, opts)
+		if err != nil {
+			log.WithError(err).Warnln("reaper: cannot list secrets")
+		} else {
+			for _, secret := range secrets.Items {
+				r.delete(log.WithField("secret", secret.Name), "secret", secret.ObjectMeta, func() error {
+					return r.client.CoreV1().Secrets(namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{})
+				})
+			}
+		}
+
+		// workspace claims are created only if each step runs in its own
+		// pod, and the runner might not have the permission to list them.
+		claims, err := r.client.CoreV1().PersistentVolumeClaims(namespace).List(ctx, opts)
+		if kerrors.IsForbidden(err) {
+			log.WithError(err).Debugln("reaper: cannot list persistent volume claims")
+		} else if err != nil {
+			log.WithError(err).Warnln("reaper: cannot list persistent volume claims")
+		} else {
+			for _, claim := range claims.Items {
+				r.delete(log.WithField("claim", claim.Name), "persistent volume claim", claim.ObjectMeta, func() error {
+					return r.client.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, claim.Name, metav1.DeleteOptions{})
+				})
+			}
+		}
+	}
+
+	// namespaces are created only if the runner is configured to create
+	// a random namespace per pipeline, and the runner might not have the
+	// permission to list them.
+	namespaces, err := r.client.CoreV1().Namespaces().List(ctx, opts)
+	if kerrors.IsForbidden(err) {
+		logrus.WithError(err).Debugln("reaper: cannot list namespaces")
+	} else if err != nil {
+		logrus.WithError(err).Warnln("reaper: cannot list namespaces")
+	} else {
+		for _, namespace := range namespaces.Items {
+			r.delete(logrus.WithField("namespace", namespace.Name), "namespace", namespace.ObjectMeta, func() error {
+				return r.client.CoreV1().Namespaces().Delete(ctx, namespace.Name, metav1.DeleteOptions{})
+			})
+		}
+	}
+}
+
+// delete deletes the resource, using the provided delete function, if the resource is orphaned.
+func (r *reaper) delete(log *logrus.Entry, kind string, meta metav1.ObjectMeta, deleteFn func() error) {
+	if !r.isOrphaned(meta) {
+		return
+	}
+
+	log = log.WithField("age", time.Since(meta.CreationTimestamp.Time).Round(time.Second))
+
+	if r.dryRun {
+		log.Infof("reaper: found orphaned %s (dry run)", kind)
+		return
+	}
+
+	if err := deleteFn(); err != nil && !kerrors.IsNotFound(err) {
+		log.WithError(err).Warnf("reaper: cannot delete orphaned %s", kind)
+		return
+	}
+
+	log.Infof("reaper: deleted orphaned %s", kind)
+}
+
+// isOrphaned returns true if the resource is old enough and
+// doesn't belong to any pipeline that is currently running.
+func (r *reaper) isOrphaned(meta metav1.ObjectMeta) bool {
+	if meta.DeletionTimestamp != nil {
+		return false // already being deleted
+	}
+
+	if time.Since(meta.CreationTimestamp.Time) < r.minAge {
+		return false
+	}
+
+	return !r.isActive(meta.Labels["io.drone.name"])
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReaper(t *testing.T) {
+	old := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	recent := metav1.NewTime(time.Now().Add(-time.Minute))
+
+	meta := func(name, runner, instance, stage string, created metav1.Time) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: created,
+			Labels: map[string]string{
+				"io.drone":                 "true",
+				"io.drone.name":            stage,
+				"io.drone.runner.name":     runner,
+				"io.drone.runner.instance": instance,
+			},
+		}
+	}
+
+	client := fake.NewSimpleClientset(
+		&v1.Pod{ObjectMeta: meta("orphaned", "runner-1", "runner-1-0", "orphaned", old)},
+		&v1.Pod{ObjectMeta: meta("active", "runner-1", "runner-1-0", "active", old)},
+		&v1.Pod{ObjectMeta: meta("recent", "runner-1", "runner-1-0", "recent", recent)},
+		&v1.Pod{ObjectMeta: meta("foreign", "runner-2", "runner-2-0", "foreign", old)},
+		&v1.Pod{ObjectMeta: meta("replica", "runner-1", "runner-1-1", "replica", old)},
+		&v1.Secret{ObjectMeta: meta("orphaned-secret", "runner-1", "runner-1-0", "orphaned", old)},
+		&v1.Secret{ObjectMeta: meta("active-secret", "runner-1", "runner-1-0", "active", old)},
+		&v1.PersistentVolumeClaim{ObjectMeta: meta("orphaned", "runner-1", "runner-1-0", "orphaned", old)},
+	)
+
+	r := &reaper{
+		client:     client,
+		runner:     "Runner 1",
+		instance:   "runner-1-0",
+		namespaces: []string{"default"},
+		minAge:     time.Hour,
+		isActive:   func(name string) bool { return name == "active" },
+	}
+
+	r.dryRun = true
+	r.reap(context.Background())
+
+	if want, got := []string{"active", "foreign", "orphaned", "recent", "replica"}, podNames(t, r); !cmp.Equal(want, got) {
+		t.Errorf("dry run must not delete pods, diff: %s", cmp.Diff(want, got))
+	}
+
+	r.dryRun = false
+	r.reap(context.Background())
+
+	if want, got := []string{"active", "foreign", "recent", "replica"}, podNames(t, r); !cmp.Equal(want, got) {
+		t.Errorf("unexpected pods, diff: %s", cmp.Diff(want, got))
+	}
+
+	secrets, err := client.CoreV1().Secrets("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Items) != 1 || secrets.Items[0].Name != "active-secret" {
+		t.Errorf("expected only the active secret to remain, got %v", secrets.Items)
+	}
+
+	claims, err := client.CoreV1().PersistentVolumeClaims("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Items) != 0 {
+		t.Errorf("expected the orphaned workspace claim to be deleted, got %v", claims.Items)
+	}
+}
+
+func podNames(t *testing.T, r *reaper) []string {
+	pods, err := r.client.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, pod := range pods.Items {
+		names = append(names, pod.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
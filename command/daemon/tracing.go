@@ -0,0 +1,75 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+
+	"github.com/drone/drone-go/drone"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// setupTracing registers the global tracer provider that exports
+// the spans to an OTLP collector. The returned function flushes
+// the pending spans and stops the exporter.
+func setupTracing(ctx context.Context, config Config) (func(context.Context) error, error) {
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(config.Tracing.Endpoint),
+	}
+	if config.Tracing.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.Tracing.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(config.Tracing.ServiceName),
+			attribute.String("drone.runner.name", config.Runner.Name),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// traceStage wraps the dispatch function so that each stage is traced
+// with a root span. The spans of the stage's compilation and execution
+// are the children of this span.
+func traceStage(dispatch func(context.Context, *drone.Stage) error) func(context.Context, *drone.Stage) error {
+	tracer := otel.Tracer("github.com/drone-runners/drone-runner-kube/command/daemon")
+
+	return func(ctx context.Context, stage *drone.Stage) error {
+		ctx, span := tracer.Start(ctx, "Stage")
+		defer span.End()
+
+		span.SetAttributes(
+			attribute.Int64("drone.stage.id", stage.ID),
+			attribute.Int64("drone.build.id", stage.BuildID),
+		)
+
+		err := dispatch(ctx, stage)
+		if err != nil {
+			span.RecordError(err)
+		}
+
+		return err
+	}
+}
@@ -53,6 +53,7 @@ type execCommand struct {
 	Environ       map[string]string
 	Labels        map[string]string
 	Secrets       map[string]string
+	SecretPerStep bool
 	Resource      compiler.Resources
 	StageRequests compiler.ResourceObject
 	Namespace     string
@@ -73,6 +74,9 @@ type execCommand struct {
 
 	Engine struct {
 		ContainerStartTimeout int
+		PodScheduleTimeout    int
+		LogStreamTimeout      int
+		NativeSidecars        bool
 	}
 
 	KubeClient kube.ClientConfig
@@ -169,6 +173,7 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 		StageRequests: c.StageRequests,
 		Namespace:     c.Namespace,
 		Policies:      policies,
+		SecretPerStep: c.SecretPerStep,
 	}
 
 	args := runtime.CompilerArgs{
@@ -267,13 +272,16 @@ func (c *execCommand) run(*kingpin.ParseContext) error {
 	)
 
 	// change to out-of-cluster for local testing
-	kubeClient, err := kube.NewFromConfig(&c.KubeClient, kubeconfig)
+	kubeClient, kubeConfig, err := kube.NewFromConfig(&c.KubeClient, kubeconfig)
 	if err != nil {
 		return err
 	}
 
-	engine := engine.New(kubeClient,
-		time.Duration(c.Engine.ContainerStartTimeout)*time.Second, time.Duration(0)*time.Second)
+	engine := engine.New(kubeClient, kubeConfig, nil,
+		time.Duration(c.Engine.ContainerStartTimeout)*time.Second,
+		time.Duration(c.Engine.PodScheduleTimeout)*time.Second,
+		time.Duration(c.Engine.LogStreamTimeout)*time.Second,
+		c.Engine.NativeSidecars)
 
 	err = runtime.NewExecer(
 		pipeline.NopReporter(),
@@ -328,6 +336,9 @@ func registerExec(app *kingpin.Application) {
 	cmd.Flag("secrets", "secret parameters").
 		StringMapVar(&c.Secrets)
 
+	cmd.Flag("secret-per-step", "create a separate kubernetes secret for each step").
+		BoolVar(&c.SecretPerStep)
+
 	cmd.Flag("include", "include pipeline steps").
 		StringsVar(&c.Include)
 
@@ -419,6 +430,17 @@ func registerExec(app *kingpin.Application) {
 		Default("480").
 		IntVar(&c.Engine.ContainerStartTimeout)
 
+	cmd.Flag("engine-pod-schedule-timeout", "number of seconds to wait for the pod to be scheduled to a node").
+		Default("300").
+		IntVar(&c.Engine.PodScheduleTimeout)
+
+	cmd.Flag("engine-log-stream-timeout", "number of seconds to wait for log streams to finish").
+		Default("30").
+		IntVar(&c.Engine.LogStreamTimeout)
+
+	cmd.Flag("engine-native-sidecars", "run the services as native sidecar containers, if the cluster supports them").
+		BoolVar(&c.Engine.NativeSidecars)
+
 	cmd.Flag("kube-client-qps", "k8s client throttle control: maximum queries per second").
 		Float32Var(&c.KubeClient.QPS)
 
@@ -0,0 +1,222 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package cluster dispatches the pipelines to multiple Kubernetes clusters.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/pipeline/runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/client-go/kubernetes"
+)
+
+var clusterHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "drone_cluster_healthy",
+	Help: "Whether the Kubernetes cluster is healthy (1) or not (0), partitioned by the cluster name.",
+}, []string{"cluster"})
+
+// ErrNoCluster is returned if no healthy cluster can run a pipeline.
+var ErrNoCluster = errors.New("no healthy cluster matches the pipeline")
+
+// Cluster is a Kubernetes cluster with its own engine.
+type Cluster struct {
+	// Name identifies the cluster. A pipeline or a policy selects the cluster by its name.
+	Name string
+
+	// Arch holds the architectures of the cluster's nodes. If empty, the cluster runs pipelines of any architecture.
+	Arch []string
+
+	// Client is used to check the health of the cluster. Its requests should have a timeout,
+	// the health check of an unreachable cluster otherwise doesn't fail until the connection does.
+	Client kubernetes.Interface
+
+	// Engine runs the pipelines in the cluster.
+	Engine runtime.Engine
+
+	mx      sync.Mutex
+	healthy bool
+}
+
+// Healthy returns true if the last health check of the cluster succeeded.
+func (c *Cluster) Healthy() bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.healthy
+}
+
+func (c *Cluster) setHealthy(healthy bool) {
+	c.mx.Lock()
+	c.healthy = healthy
+	c.mx.Unlock()
+
+	if healthy {
+		clusterHealthy.WithLabelValues(c.Name).Set(1)
+	} else {
+		clusterHealthy.WithLabelValues(c.Name).Set(0)
+	}
+}
+
+// matches returns true if the cluster can run pipelines of the architecture.
+func (c *Cluster) matches(arch string) bool {
+	if len(c.Arch) == 0 {
+		return true
+	}
+	if arch == "" {
+		arch = "amd64"
+	}
+	for _, a := range c.Arch {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// Router implements a pipeline engine that runs each pipeline with the engine of the selected cluster.
+// A pipeline runs in the cluster named by its cluster key or a policy. Otherwise, it runs in the first
+// healthy cluster, in the configured order, that has nodes of the pipeline's architecture.
+type Router struct {
+	clusters []*Cluster
+	stages   *sync.Map
+}
+
+// New returns a new Router. The clusters are considered healthy until the first health check.
+func New(clusters ...*Cluster) *Router {
+	for _, c := range clusters {
+		c.setHealthy(true)
+	}
+
+	return &Router{
+		clusters: clusters,
+		stages:   &sync.Map{},
+	}
+}
+
+// Start checks the health of the clusters periodically, until the context is done.
+func (r *Router) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			r.checkHealth(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// checkHealth checks whether the API server of each cluster responds. The clusters are
+// checked concurrently, an unreachable cluster doesn't delay the check of the others.
+func (r *Router) checkHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, c := range r.clusters {
+		wg.Add(1)
+		go func(c *Cluster) {
+			defer wg.Done()
+			c.checkHealth(ctx)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// checkHealth checks whether the API server of the cluster responds.
+func (c *Cluster) checkHealth(ctx context.Context) {
+	_, err := c.Client.Discovery().ServerVersion()
+	if ctx.Err() != nil {
+		return
+	}
+
+	log := logger.FromContext(ctx).WithField("cluster", c.Name)
+	if err != nil && c.Healthy() {
+		log.WithError(err).Warn("cluster is unhealthy")
+	} else if err == nil && !c.Healthy() {
+		log.Info("cluster is healthy")
+	}
+
+	c.setHealthy(err == nil)
+}
+
+// Select returns the cluster to run the pipeline in.
+func (r *Router) Select(spec *engine.Spec) (*Cluster, error) {
+	if spec.Cluster != "" {
+		for _, c := range r.clusters {
+			if c.Name != spec.Cluster {
+				continue
+			}
+			if !c.Healthy() {
+				return nil, fmt.Errorf("cluster %q is unhealthy", spec.Cluster)
+			}
+			return c, nil
+		}
+		return nil, fmt.Errorf("unknown cluster %q", spec.Cluster)
+	}
+
+	for _, c := range r.clusters {
+		if c.Healthy() && c.matches(spec.Platform.Arch) {
+			return c, nil
+		}
+	}
+
+	return nil, ErrNoCluster
+}
+
+// Setup the pipeline environment in the selected cluster.
+func (r *Router) Setup(ctx context.Context, specv runtime.Spec) error {
+	spec := specv.(*engine.Spec)
+
+	c, err := r.Select(spec)
+	if err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).
+		WithField("pod", spec.PodSpec.Name).
+		WithField("cluster", c.Name).
+		Debug("selected cluster")
+
+	r.stages.Store(spec.PodSpec.Name, c)
+
+	return c.Engine.Setup(ctx, spec)
+}
+
+// Destroy the pipeline environment.
+func (r *Router) Destroy(ctx context.Context, specv runtime.Spec) error {
+	spec := specv.(*engine.Spec)
+
+	c, loaded := r.stages.LoadAndDelete(spec.PodSpec.Name)
+	if !loaded {
+		return nil // the pipeline environment was not set up
+	}
+
+	return c.(*Cluster).Engine.Destroy(ctx, spec)
+}
+
+// Run runs the pipeline step.
+func (r *Router) Run(ctx context.Context, specv runtime.Spec, stepv runtime.Step, output io.Writer) (*runtime.State, error) {
+	spec := specv.(*engine.Spec)
+
+	c, ok := r.stages.Load(spec.PodSpec.Name)
+	if !ok {
+		return nil, fmt.Errorf("pipeline %s is not set up", spec.PodSpec.Name)
+	}
+
+	return c.(*Cluster).Engine.Run(ctx, spec, stepv, output)
+}
@@ -0,0 +1,217 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+
+	"github.com/drone/runner-go/pipeline/runtime"
+
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// unreachableClient is a kubernetes client of a cluster whose API server doesn't respond.
+type unreachableClient struct {
+	*fake.Clientset
+}
+
+func (c *unreachableClient) Discovery() discovery.DiscoveryInterface {
+	return &unreachableDiscovery{c.Clientset.Discovery().(*fakediscovery.FakeDiscovery)}
+}
+
+type unreachableDiscovery struct {
+	*fakediscovery.FakeDiscovery
+}
+
+func (d *unreachableDiscovery) ServerVersion() (*version.Info, error) {
+	return nil, errors.New("connection refused")
+}
+
+// slowClient is a kubernetes client of a cluster whose API server responds once released.
+type slowClient struct {
+	*fake.Clientset
+	release chan struct{}
+}
+
+func (c *slowClient) Discovery() discovery.DiscoveryInterface {
+	return &slowDiscovery{c.Clientset.Discovery().(*fakediscovery.FakeDiscovery), c.release}
+}
+
+type slowDiscovery struct {
+	*fakediscovery.FakeDiscovery
+	release chan struct{}
+}
+
+func (d *slowDiscovery) ServerVersion() (*version.Info, error) {
+	<-d.release
+	return d.FakeDiscovery.ServerVersion()
+}
+
+// recorder is a pipeline engine that records the pipelines it runs.
+type recorder struct {
+	setup, run, destroy []string
+}
+
+func (r *recorder) Setup(_ context.Context, spec runtime.Spec) error {
+	r.setup = append(r.setup, spec.(*engine.Spec).PodSpec.Name)
+	return nil
+}
+
+func (r *recorder) Destroy(_ context.Context, spec runtime.Spec) error {
+	r.destroy = append(r.destroy, spec.(*engine.Spec).PodSpec.Name)
+	return nil
+}
+
+func (r *recorder) Run(_ context.Context, spec runtime.Spec, _ runtime.Step, _ io.Writer) (*runtime.State, error) {
+	r.run = append(r.run, spec.(*engine.Spec).PodSpec.Name)
+	return &runtime.State{Exited: true}, nil
+}
+
+func TestRouter_Select(t *testing.T) {
+	amd64 := &Cluster{Name: "amd64", Arch: []string{"amd64"}, Client: fake.NewSimpleClientset()}
+	arm64 := &Cluster{Name: "arm64", Arch: []string{"arm64"}, Client: fake.NewSimpleClientset()}
+	gpu := &Cluster{Name: "gpu", Client: fake.NewSimpleClientset()}
+
+	router := New(amd64, arm64, gpu)
+
+	tests := []struct {
+		cluster, arch string
+		want          *Cluster
+		err           bool
+	}{
+		{arch: "", want: amd64},
+		{arch: "amd64", want: amd64},
+		{arch: "arm64", want: arm64},
+		{arch: "arm", want: gpu}, // the cluster without architectures accepts any
+		{cluster: "gpu", arch: "arm64", want: gpu},
+		{cluster: "unknown", err: true},
+	}
+
+	for _, test := range tests {
+		spec := &engine.Spec{Cluster: test.cluster, Platform: engine.Platform{Arch: test.arch}}
+		got, err := router.Select(spec)
+		if test.err {
+			if err == nil {
+				t.Errorf("cluster=%q arch=%q: expected an error", test.cluster, test.arch)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("cluster=%q arch=%q: unexpected error: %s", test.cluster, test.arch, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("cluster=%q arch=%q: want cluster %s, got %s", test.cluster, test.arch, test.want.Name, got.Name)
+		}
+	}
+}
+
+func TestRouter_Unhealthy(t *testing.T) {
+	primary := &Cluster{Name: "primary", Client: &unreachableClient{fake.NewSimpleClientset()}}
+	secondary := &Cluster{Name: "secondary", Client: fake.NewSimpleClientset()}
+
+	router := New(primary, secondary)
+	router.checkHealth(context.Background())
+
+	if primary.Healthy() {
+		t.Errorf("expected the unreachable cluster to be unhealthy")
+	}
+	if !secondary.Healthy() {
+		t.Errorf("expected the reachable cluster to be healthy")
+	}
+
+	got, err := router.Select(&engine.Spec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != secondary {
+		t.Errorf("expected the unhealthy cluster to be skipped, got %s", got.Name)
+	}
+
+	if _, err = router.Select(&engine.Spec{Cluster: "primary"}); err == nil {
+		t.Errorf("expected an error selecting the unhealthy cluster by name")
+	}
+
+	router.clusters = []*Cluster{primary}
+	if _, err = router.Select(&engine.Spec{}); err != ErrNoCluster {
+		t.Errorf("want error %s, got %v", ErrNoCluster, err)
+	}
+}
+
+func TestRouter_CheckHealthConcurrently(t *testing.T) {
+	release := make(chan struct{})
+	slow := &Cluster{Name: "slow", Client: &slowClient{fake.NewSimpleClientset(), release}}
+	unreachable := &Cluster{Name: "unreachable", Client: &unreachableClient{fake.NewSimpleClientset()}}
+
+	router := New(slow, unreachable)
+
+	done := make(chan struct{})
+	go func() {
+		router.checkHealth(context.Background())
+		close(done)
+	}()
+
+	// the slow cluster doesn't delay the health check of the other clusters.
+	deadline := time.Now().Add(time.Second)
+	for unreachable.Healthy() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if unreachable.Healthy() {
+		t.Errorf("expected the unreachable cluster to be checked while the slow cluster responds")
+	}
+
+	close(release)
+	<-done
+
+	if !slow.Healthy() {
+		t.Errorf("expected the slow cluster to be healthy")
+	}
+}
+
+func TestRouter_Dispatch(t *testing.T) {
+	first, second := &recorder{}, &recorder{}
+	router := New(
+		&Cluster{Name: "first", Client: fake.NewSimpleClientset(), Engine: first},
+		&Cluster{Name: "second", Client: fake.NewSimpleClientset(), Engine: second},
+	)
+
+	ctx := context.Background()
+	spec := &engine.Spec{Cluster: "second", PodSpec: engine.PodSpec{Name: "drone-pod"}}
+
+	if err := router.Setup(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := router.Run(ctx, spec, &engine.Step{}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Destroy(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(first.setup)+len(first.run)+len(first.destroy) != 0 {
+		t.Errorf("expected the pipeline not to run in the first cluster")
+	}
+	if len(second.setup) != 1 || len(second.run) != 1 || len(second.destroy) != 1 {
+		t.Errorf("expected the pipeline to run in the second cluster, got setup=%v run=%v destroy=%v",
+			second.setup, second.run, second.destroy)
+	}
+
+	// the pipeline is destroyed only once.
+	if err := router.Destroy(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(second.destroy) != 1 {
+		t.Errorf("expected the pipeline to be destroyed once, got %d", len(second.destroy))
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+)
+
+// helper function converts the pipeline affinity.
+func convertAffinity(src *resource.Affinity) *engine.Affinity {
+	if src == nil {
+		return nil
+	}
+	dst := &engine.Affinity{
+		PodAffinity:     convertPodAffinity(src.PodAffinity),
+		PodAntiAffinity: convertPodAffinity(src.PodAntiAffinity),
+	}
+	if src.NodeAffinity != nil {
+		dst.NodeAffinity = &engine.NodeAffinity{}
+		for _, term := range src.NodeAffinity.Required {
+			dst.NodeAffinity.Required = append(dst.NodeAffinity.Required, convertNodeSelectorTerm(term))
+		}
+		for _, term := range src.NodeAffinity.Preferred {
+			dst.NodeAffinity.Preferred = append(dst.NodeAffinity.Preferred, engine.PreferredSchedulingTerm{
+				Weight:     term.Weight,
+				Preference: convertNodeSelectorTerm(term.Preference),
+			})
+		}
+	}
+	return dst
+}
+
+// helper function converts the pod affinity or anti-affinity.
+func convertPodAffinity(src *resource.PodAffinity) *engine.PodAffinity {
+	if src == nil {
+		return nil
+	}
+	dst := &engine.PodAffinity{}
+	for _, term := range src.Required {
+		dst.Required = append(dst.Required, convertPodAffinityTerm(term))
+	}
+	for _, term := range src.Preferred {
+		dst.Preferred = append(dst.Preferred, engine.WeightedPodAffinityTerm{
+			Weight:          term.Weight,
+			PodAffinityTerm: convertPodAffinityTerm(term.PodAffinityTerm),
+		})
+	}
+	return dst
+}
+
+func convertPodAffinityTerm(src resource.PodAffinityTerm) engine.PodAffinityTerm {
+	return engine.PodAffinityTerm{
+		LabelSelector: convertLabelSelector(src.LabelSelector),
+		Namespaces:    src.Namespaces,
+		TopologyKey:   src.TopologyKey,
+	}
+}
+
+func convertNodeSelectorTerm(src resource.NodeSelectorTerm) engine.NodeSelectorTerm {
+	return engine.NodeSelectorTerm{
+		MatchExpressions: convertSelectorRequirements(src.MatchExpressions),
+		MatchFields:      convertSelectorRequirements(src.MatchFields),
+	}
+}
+
+// helper function converts the pipeline topology spread constraints.
+func convertTopologySpreadConstraints(src []resource.TopologySpreadConstraint) []engine.TopologySpreadConstraint {
+	var dst []engine.TopologySpreadConstraint
+	for _, c := range src {
+		dst = append(dst, engine.TopologySpreadConstraint{
+			MaxSkew:           c.MaxSkew,
+			TopologyKey:       c.TopologyKey,
+			WhenUnsatisfiable: c.WhenUnsatisfiable,
+			LabelSelector:     convertLabelSelector(c.LabelSelector),
+		})
+	}
+	return dst
+}
+
+func convertLabelSelector(src *resource.LabelSelector) *engine.LabelSelector {
+	if src == nil {
+		return nil
+	}
+	return &engine.LabelSelector{
+		MatchLabels:      src.MatchLabels,
+		MatchExpressions: convertSelectorRequirements(src.MatchExpressions),
+	}
+}
+
+func convertSelectorRequirements(src []resource.SelectorRequirement) []engine.SelectorRequirement {
+	var dst []engine.SelectorRequirement
+	for _, r := range src {
+		dst = append(dst, engine.SelectorRequirement{
+			Key:      r.Key,
+			Operator: r.Operator,
+			Values:   r.Values,
+		})
+	}
+	return dst
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/resource"
+
+	"github.com/drone/runner-go/manifest"
+	"github.com/google/go-cmp/cmp"
+)
+
+const affinityPipeline = `
+kind: pipeline
+type: kubernetes
+name: default
+
+steps:
+- name: build
+  image: golang
+
+affinity:
+  node_affinity:
+    required:
+    - match_expressions:
+      - key: nvidia.com/gpu.present
+        operator: In
+        values: [ "true" ]
+  pod_anti_affinity:
+    preferred:
+    - weight: 100
+      pod_affinity_term:
+        label_selector:
+          match_labels:
+            io.drone.repo.name: heavy
+        topology_key: kubernetes.io/hostname
+
+topology_spread_constraints:
+- max_skew: 1
+  topology_key: topology.kubernetes.io/zone
+  when_unsatisfiable: DoNotSchedule
+`
+
+func TestConvertAffinity(t *testing.T) {
+	m, err := manifest.ParseString(affinityPipeline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline := m.Resources[0].(*resource.Pipeline)
+
+	wantAffinity := &engine.Affinity{
+		NodeAffinity: &engine.NodeAffinity{
+			Required: []engine.NodeSelectorTerm{{
+				MatchExpressions: []engine.SelectorRequirement{
+					{Key: "nvidia.com/gpu.present", Operator: "In", Values: []string{"true"}},
+				},
+			}},
+		},
+		PodAntiAffinity: &engine.PodAffinity{
+			Preferred: []engine.WeightedPodAffinityTerm{{
+				Weight: 100,
+				PodAffinityTerm: engine.PodAffinityTerm{
+					LabelSelector: &engine.LabelSelector{MatchLabels: map[string]string{"io.drone.repo.name": "heavy"}},
+					TopologyKey:   "kubernetes.io/hostname",
+				},
+			}},
+		},
+	}
+	if diff := cmp.Diff(wantAffinity, convertAffinity(pipeline.Affinity)); diff != "" {
+		t.Errorf("Unexpected affinity")
+		t.Log(diff)
+	}
+
+	wantConstraints := []engine.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       "topology.kubernetes.io/zone",
+		WhenUnsatisfiable: "DoNotSchedule",
+	}}
+	if diff := cmp.Diff(wantConstraints, convertTopologySpreadConstraints(pipeline.TopologySpreadConstraints)); diff != "" {
+		t.Errorf("Unexpected topology spread constraints")
+		t.Log(diff)
+	}
+}
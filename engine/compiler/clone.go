@@ -17,11 +17,12 @@ import (
 const cloneStepName = "clone"
 
 // helper function returns the clone image based on the
-// target operating system.
+// target operating system. The windows images must match
+// the windows release of the node.
 func cloneImage(platform manifest.Platform) string {
 	switch platform.OS {
 	case "windows":
-		return "drone/git:latest"
+		return "drone/git:windows-" + windowsRelease(platform) + "-amd64"
 	default:
 		return "drone/git:latest"
 	}
@@ -48,7 +49,7 @@ func createClone(src *resource.Pipeline) *engine.Step {
 	return &engine.Step{
 		Name:        cloneStepName,
 		Image:       cloneImage(src.Platform),
-		Placeholder: placeholderImage,
+		Placeholder: placeholder(src.Platform),
 		RunPolicy:   runtime.RunAlways,
 		Envs:        cloneParams(src.Clone),
 	}
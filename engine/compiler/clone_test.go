@@ -120,7 +120,11 @@ func TestCloneImage(t *testing.T) {
 		},
 		{
 			in:  manifest.Platform{OS: "windows"},
-			out: "drone/git:latest",
+			out: "drone/git:windows-1809-amd64",
+		},
+		{
+			in:  manifest.Platform{OS: "windows", Version: "2022"},
+			out: "drone/git:windows-ltsc2022-amd64",
 		},
 	}
 	for _, test := range tests {
@@ -8,8 +8,10 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
+	"github.com/drone-runners/drone-runner-kube/engine/mesh"
 	"github.com/drone-runners/drone-runner-kube/engine/policy"
 	"github.com/drone-runners/drone-runner-kube/engine/resource"
 	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
@@ -28,6 +30,8 @@ import (
 
 	"github.com/dchest/uniuri"
 	"github.com/gosimple/slug"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/apimachinery/pkg/util/validation"
 )
 
@@ -70,6 +74,14 @@ type (
 		ED25519 string
 	}
 
+	// DebugContainer defines the settings of the ephemeral
+	// debug containers, an alternative to tmate.
+	DebugContainer struct {
+		Enabled     bool
+		Image       string
+		IdleTimeout time.Duration
+	}
+
 	// Compiler compiles the Yaml configuration file to an
 	// intermediate representation optimized for simple execution.
 	Compiler struct {
@@ -116,6 +128,11 @@ type (
 		// live debugging.
 		Tmate Tmate
 
+		// DebugContainer provides global configuration options
+		// for live debugging with ephemeral containers. If it is
+		// enabled, it takes precedence over tmate.
+		DebugContainer DebugContainer
+
 		// Cloner provides an option to override the default clone
 		// image used to clone the repository when the pipeline
 		// initializes.
@@ -126,6 +143,13 @@ type (
 		// for execution.
 		Placeholder string
 
+		// ClonerWindows and PlaceholderWindows override the
+		// default clone and placeholder images of the windows
+		// pipelines, which must match the windows release of
+		// the nodes.
+		ClonerWindows      string
+		PlaceholderWindows string
+
 		// Namespace provides the default kubernetes namespace
 		// when no namespace is provided.
 		Namespace string
@@ -137,6 +161,27 @@ type (
 		// NodeSelector provides the default kubernetes node selector.
 		NodeSelector map[string]string
 
+		// Runner provides the name of the runner. It is used to label
+		// the kubernetes resources, so that the runner can find and
+		// remove the resources it left behind.
+		Runner string
+
+		// RunnerInstance provides the name of the runner process. It is
+		// used to label the kubernetes resources, so that the replicas of
+		// a runner don't remove the resources of each other's pipelines.
+		RunnerInstance string
+
+		// SecretPerStep instructs the engine to create a separate
+		// kubernetes secret for each pipeline step, so that a step
+		// can access only its own secrets.
+		SecretPerStep bool
+
+		// SidecarInjection controls whether a service mesh, such as
+		// Istio or Linkerd, injects its proxy into the pipeline pods.
+		// The value is one of the mesh.Injection modes. The pipeline
+		// annotations take precedence.
+		SidecarInjection string
+
 		// Policy provides a set of policies used to set defaults
 		// based on matching logic.
 		Policies []*policy.Policy
@@ -145,9 +190,21 @@ type (
 
 // Compile compiles the configuration file.
 func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runtime.Spec {
+	// the stage's span is carried by the spec to the engine's Setup and Destroy methods.
+	stageSpan := trace.SpanFromContext(ctx)
+	stageSpan.SetAttributes(stageAttributes(args)...)
+
+	ctx, span := tracer.Start(ctx, "Compile")
+	defer span.End()
+
 	pipeline := args.Pipeline.(*resource.Pipeline)
 	os := pipeline.Platform.OS
-	arch := pipeline.Platform.Arch
+
+	// the clone and placeholder images are platform specific.
+	overrideCloner, overridePlaceholder := c.Cloner, c.Placeholder
+	if os == "windows" {
+		overrideCloner, overridePlaceholder = c.ClonerWindows, c.PlaceholderWindows
+	}
 
 	// create the workspace paths
 	workspace := createWorkspace(pipeline)
@@ -167,6 +224,7 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 	// create annotations
 	podAnnotations := labels.Combine(
 		c.Annotations,
+		mesh.Annotations(c.SidecarInjection),
 		labels.FromRepo(args.Repo),
 		labels.FromBuild(args.Build),
 		labels.FromStage(args.Stage),
@@ -225,8 +283,11 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 			Variant: pipeline.Platform.Variant,
 			Version: pipeline.Platform.Version,
 		},
-		Secrets: map[string]*engine.Secret{},
-		Volumes: []*engine.Volume{workVolume, statusVolume},
+		Cluster:       pipeline.Cluster,
+		Secrets:       map[string]*engine.Secret{},
+		SecretPerStep: c.SecretPerStep,
+		Volumes:       []*engine.Volume{workVolume, statusVolume},
+		SpanContext:   stageSpan.SpanContext(),
 	}
 
 	// set default namespace
@@ -283,6 +344,10 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		})
 	}
 
+	// add affinity and topology spread constraints
+	spec.PodSpec.Affinity = convertAffinity(pipeline.Affinity)
+	spec.PodSpec.TopologySpreadConstraints = convertTopologySpreadConstraints(pipeline.TopologySpreadConstraints)
+
 	// list the global environment variables
 	globals, _ := c.Environ.List(ctx, &provider.Request{
 		Build: args.Build,
@@ -328,11 +393,6 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		envs["DRONE_TMATE_FINGERPRINT_ED25519"] = c.Tmate.ED25519
 	}
 
-	// set platform if needed
-	if arch == "arm" || arch == "arm64" {
-		spec.PodSpec.Labels["kubernetes.io/arch"] = arch
-	}
-
 	// set drone labels
 	spec.PodSpec.Labels["io.drone"] = "true"
 	spec.PodSpec.Labels["io.drone.name"] = spec.PodSpec.Name
@@ -340,6 +400,12 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 	spec.PodSpec.Labels["io.drone.repo.name"] = slug.Make(args.Repo.Name)
 	spec.PodSpec.Labels["io.drone.build.number"] = fmt.Sprint(args.Build.Number)
 	spec.PodSpec.Labels["io.drone.build.event"] = slug.Make(args.Build.Event)
+	if c.Runner != "" {
+		spec.PodSpec.Labels["io.drone.runner.name"] = LabelValue(c.Runner)
+	}
+	if c.RunnerInstance != "" {
+		spec.PodSpec.Labels["io.drone.runner.instance"] = LabelValue(c.RunnerInstance)
+	}
 
 	match := manifest.Match{
 		Action:   args.Build.Action,
@@ -362,13 +428,13 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		spec.Steps = append(spec.Steps, step)
 
 		// override default clone image.
-		if c.Cloner != "" {
-			step.Image = c.Cloner
+		if overrideCloner != "" {
+			step.Image = overrideCloner
 		}
 
 		// override default placeholder image.
-		if c.Placeholder != "" {
-			step.Placeholder = c.Placeholder
+		if overridePlaceholder != "" {
+			step.Placeholder = overridePlaceholder
 		}
 	}
 
@@ -378,6 +444,7 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 	for _, src := range pipeline.Services {
 		dst := createStep(pipeline, src)
 		dst.Detach = true
+		dst.Service = true
 		dst.Envs = environ.Combine(envs, dst.Envs)
 		dst.Volumes = append(dst.Volumes, workMount, statusMount)
 		setupScript(src, dst, os)
@@ -391,8 +458,8 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		}
 
 		// override default placeholder image.
-		if c.Placeholder != "" {
-			dst.Placeholder = c.Placeholder
+		if overridePlaceholder != "" {
+			dst.Placeholder = overridePlaceholder
 		}
 
 		if len(validation.IsDNS1123Subdomain(src.Name)) == 0 {
@@ -420,8 +487,8 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		}
 
 		// override default placeholder image.
-		if c.Placeholder != "" {
-			dst.Placeholder = c.Placeholder
+		if overridePlaceholder != "" {
+			dst.Placeholder = overridePlaceholder
 		}
 
 		if dst.Detach && len(validation.IsDNS1123Subdomain(src.Name)) == 0 {
@@ -452,8 +519,20 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		})
 	}
 
+	// keep the pod of a failed step alive for debugging with an
+	// ephemeral container if build running in debug mode. The
+	// ephemeral containers can't share the process namespace of
+	// a step on windows.
+	isDebugContainer := c.DebugContainer.Enabled && args.Build.Debug && pipeline.Platform.OS != "windows"
+	if isDebugContainer {
+		spec.Debug = &engine.Debug{
+			Image:       image.Expand(c.DebugContainer.Image),
+			IdleTimeout: c.DebugContainer.IdleTimeout,
+		}
+	}
+
 	// create internal steps if build running in debug mode
-	if c.Tmate.Enabled && args.Build.Debug && pipeline.Platform.OS != "windows" {
+	if c.Tmate.Enabled && !isDebugContainer && args.Build.Debug && pipeline.Platform.OS != "windows" {
 		// first we need to add an internal setup step to the pipeline
 		// to copy over the tmate binary. Internal steps are not visible
 		// to the end user.
@@ -533,10 +612,12 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 	}
 
 	// get registry credentials from registry plugins
-	creds, err := c.Registry.List(ctx, &registry.Request{
+	registryCtx, registrySpan := tracer.Start(ctx, "Registry.List")
+	creds, err := c.Registry.List(registryCtx, &registry.Request{
 		Repo:  args.Repo,
 		Build: args.Build,
 	})
+	registrySpan.End()
 	if err != nil {
 		// TODO (bradrydzewski) return an error to the caller
 		// if the provider returns an error.
@@ -637,6 +718,14 @@ func (c *Compiler) Compile(ctx context.Context, args runtime.CompilerArgs) runti
 		m.Apply(spec)
 	}
 
+	// schedule the pod on the nodes of the target platform.
+	// the node labels set by the pipeline, the runner or a
+	// policy take precedence.
+	spec.PodSpec.NodeSelector = environ.Combine(
+		platformNodeSelector(pipeline.Platform),
+		spec.PodSpec.NodeSelector,
+	)
+
 	// Find values for pod-level resources request and resources limits.
 	// The highest precedence have values set by a policy, next are values from yaml/parameters
 	// and finally the last are environment variables.
@@ -767,6 +856,11 @@ func (c *Compiler) findSecret(ctx context.Context, args runtime.CompilerArgs, na
 		c.Secret,
 	)
 
+	ctx, span := tracer.Start(ctx, "Secret.Find", trace.WithAttributes(
+		attribute.String("drone.secret.name", name),
+	))
+	defer span.End()
+
 	// TODO return an error to the caller if the provider
 	// returns an error.
 	found, _ := provider.Find(ctx, &secret.Request{
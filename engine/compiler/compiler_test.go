@@ -14,6 +14,7 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/dchest/uniuri"
 	"github.com/drone-runners/drone-runner-kube/engine"
@@ -172,6 +173,45 @@ func TestCompile_Secrets(t *testing.T) {
 	}
 }
 
+// This test verifies that a build in debug mode is debugged with
+// an ephemeral container, instead of tmate, if it's enabled.
+func TestCompile_DebugContainer(t *testing.T) {
+	manifest, _ := manifest.ParseFile("testdata/serial.yml")
+
+	compiler := &Compiler{
+		Environ:  provider.Static(nil),
+		Registry: registry.Static(nil),
+		Secret:   secret.Static(nil),
+		Tmate:    Tmate{Enabled: true, Image: "drone/drone-runner-docker:1"},
+		DebugContainer: DebugContainer{
+			Enabled:     true,
+			Image:       "busybox:1",
+			IdleTimeout: time.Hour,
+		},
+	}
+	args := runtime.CompilerArgs{
+		Repo:     &drone.Repo{},
+		Build:    &drone.Build{Debug: true},
+		Stage:    &drone.Stage{},
+		System:   &drone.System{},
+		Netrc:    &drone.Netrc{},
+		Manifest: manifest,
+		Pipeline: manifest.Resources[0].(*resource.Pipeline),
+		Secret:   secret.Static(nil),
+	}
+
+	ir := compiler.Compile(nocontext, args).(*engine.Spec)
+
+	want := &engine.Debug{Image: "docker.io/library/busybox:1", IdleTimeout: time.Hour}
+	if diff := cmp.Diff(ir.Debug, want); len(diff) != 0 {
+		t.Error(diff)
+	}
+
+	if len(ir.Internal) != 0 {
+		t.Errorf("expected no tmate init container, got %d internal steps", len(ir.Internal))
+	}
+}
+
 // helper function parses and compiles the source file and then
 // compares to a golden json file.
 func testCompile(t *testing.T, source, golden string) *engine.Spec {
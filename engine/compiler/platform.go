@@ -0,0 +1,74 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"strings"
+
+	"github.com/drone/runner-go/manifest"
+)
+
+// the well-known node labels of the target platform.
+const (
+	labelOS           = "kubernetes.io/os"
+	labelArch         = "kubernetes.io/arch"
+	labelWindowsBuild = "node.kubernetes.io/windows-build"
+)
+
+// default windows release, if the pipeline doesn't set the platform version.
+const defaultWindowsRelease = "1809"
+
+// windowsBuilds maps the windows releases to their build numbers,
+// which are the values of the node.kubernetes.io/windows-build label.
+var windowsBuilds = map[string]string{
+	"1809":     "10.0.17763",
+	"1903":     "10.0.18362",
+	"1909":     "10.0.18363",
+	"2004":     "10.0.19041",
+	"20h2":     "10.0.19042",
+	"ltsc2022": "10.0.20348",
+}
+
+// helper function returns the windows release of the platform
+// version, e.g. 1809 for ltsc2019, or ltsc2022 for 2022.
+func windowsRelease(platform manifest.Platform) string {
+	switch v := strings.ToLower(platform.Version); v {
+	case "":
+		return defaultWindowsRelease
+	case "2019", "ltsc2019":
+		return "1809"
+	case "2022":
+		return "ltsc2022"
+	default:
+		return v
+	}
+}
+
+// helper function returns the node selector that schedules the
+// pipeline pod on the nodes of the target platform. The operating
+// system defaults to linux. The variant has no well-known node
+// label, the nodes of an architecture must run all its variants.
+func platformNodeSelector(platform manifest.Platform) map[string]string {
+	os := platform.OS
+	if os == "" {
+		os = "linux"
+	}
+
+	selector := map[string]string{labelOS: os}
+	if arch := platform.Arch; arch != "" {
+		selector[labelArch] = arch
+	}
+
+	// the windows containers run only on the nodes with the same
+	// windows build as the images, which are the images of the
+	// default release if the platform version is not set.
+	if os == "windows" {
+		if build, ok := windowsBuilds[windowsRelease(platform)]; ok {
+			selector[labelWindowsBuild] = build
+		}
+	}
+
+	return selector
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"testing"
+
+	"github.com/drone/runner-go/manifest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPlatformNodeSelector(t *testing.T) {
+	tests := []struct {
+		in  manifest.Platform
+		out map[string]string
+	}{
+		{
+			in:  manifest.Platform{},
+			out: map[string]string{"kubernetes.io/os": "linux"},
+		},
+		{
+			in:  manifest.Platform{OS: "linux", Arch: "arm64", Variant: "v8"},
+			out: map[string]string{"kubernetes.io/os": "linux", "kubernetes.io/arch": "arm64"},
+		},
+		{
+			// the windows release defaults to the release of the default images.
+			in: manifest.Platform{OS: "windows", Arch: "amd64"},
+			out: map[string]string{
+				"kubernetes.io/os":                 "windows",
+				"kubernetes.io/arch":               "amd64",
+				"node.kubernetes.io/windows-build": "10.0.17763",
+			},
+		},
+		{
+			in: manifest.Platform{OS: "windows", Arch: "amd64", Version: "1809"},
+			out: map[string]string{
+				"kubernetes.io/os":                 "windows",
+				"kubernetes.io/arch":               "amd64",
+				"node.kubernetes.io/windows-build": "10.0.17763",
+			},
+		},
+		{
+			in: manifest.Platform{OS: "windows", Version: "ltsc2022"},
+			out: map[string]string{
+				"kubernetes.io/os":                 "windows",
+				"node.kubernetes.io/windows-build": "10.0.20348",
+			},
+		},
+		{
+			// the unknown windows releases aren't selected by build.
+			in:  manifest.Platform{OS: "windows", Version: "nanoserver"},
+			out: map[string]string{"kubernetes.io/os": "windows"},
+		},
+	}
+	for _, test := range tests {
+		if diff := cmp.Diff(test.out, platformNodeSelector(test.in)); diff != "" {
+			t.Errorf("Unexpected node selector for platform %+v", test.in)
+			t.Log(diff)
+		}
+	}
+}
+
+func TestPlaceholder(t *testing.T) {
+	tests := []struct {
+		in  manifest.Platform
+		out string
+	}{
+		{
+			in:  manifest.Platform{},
+			out: "drone/placeholder:1",
+		},
+		{
+			in:  manifest.Platform{OS: "linux", Arch: "arm64"},
+			out: "drone/placeholder:1",
+		},
+		{
+			in:  manifest.Platform{OS: "windows"},
+			out: "drone/placeholder:1-windows-1809-amd64",
+		},
+		{
+			in:  manifest.Platform{OS: "windows", Version: "ltsc2019"},
+			out: "drone/placeholder:1-windows-1809-amd64",
+		},
+		{
+			in:  manifest.Platform{OS: "windows", Version: "ltsc2022"},
+			out: "drone/placeholder:1-windows-ltsc2022-amd64",
+		},
+	}
+	for _, test := range tests {
+		got, want := placeholder(test.in), test.out
+		if got != want {
+			t.Errorf("Want placeholder image %q, got %q", want, got)
+		}
+	}
+}
@@ -6,6 +6,7 @@ package compiler
 
 import (
 	"strings"
+	"time"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
 	"github.com/drone-runners/drone-runner-kube/engine/resource"
@@ -13,17 +14,30 @@ import (
 	"github.com/drone-runners/drone-runner-kube/internal/encoder"
 
 	"github.com/drone/runner-go/environ"
+	"github.com/drone/runner-go/manifest"
 	"github.com/drone/runner-go/pipeline/runtime"
 )
 
 const placeholderImage = "drone/placeholder:1"
 
+// helper function returns the placeholder image based on the
+// target operating system. The windows images must match the
+// windows release of the node.
+func placeholder(platform manifest.Platform) string {
+	switch platform.OS {
+	case "windows":
+		return placeholderImage + "-windows-" + windowsRelease(platform) + "-amd64"
+	default:
+		return placeholderImage
+	}
+}
+
 func createStep(spec *resource.Pipeline, src *resource.Step) *engine.Step {
 	dst := &engine.Step{
 		ID:           random(),
 		Name:         src.Name,
 		Image:        image.Expand(src.Image),
-		Placeholder:  placeholderImage,
+		Placeholder:  placeholder(spec.Platform),
 		Command:      src.Command,
 		Entrypoint:   src.Entrypoint,
 		Detach:       src.Detach,
@@ -37,6 +51,7 @@ func createStep(spec *resource.Pipeline, src *resource.Step) *engine.Step {
 		Group:        src.Group,
 		Resources:    convertResources(src.Resources),
 		Secrets:      convertSecretEnv(src.Environment),
+		Timeout:      time.Duration(src.Timeout),
 		WorkingDir:   src.WorkingDir,
 	}
 
@@ -75,6 +90,14 @@ func createStep(spec *resource.Pipeline, src *resource.Step) *engine.Step {
 		}
 	}
 
+	// the secrets can be provided as files, so that
+	// they are not exposed in the process environment.
+	if spec.SecretsAsFiles || src.SecretsAsFiles {
+		for _, secret := range dst.Secrets {
+			secret.File = true
+		}
+	}
+
 	// set the pipeline step run policy. steps run on
 	// success by default, but may be optionally configured
 	// to run on failure.
@@ -0,0 +1,31 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package compiler
+
+import (
+	"github.com/drone/runner-go/pipeline/runtime"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// tracer creates the spans of the compiler. The spans are discarded unless
+// a tracer provider is registered with otel.SetTracerProvider.
+var tracer = otel.Tracer("github.com/drone-runners/drone-runner-kube/engine/compiler")
+
+// stageAttributes returns the trace attributes that identify the stage.
+func stageAttributes(args runtime.CompilerArgs) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if args.Repo != nil {
+		attrs = append(attrs, attribute.String("drone.repo", args.Repo.Slug))
+	}
+	if args.Build != nil {
+		attrs = append(attrs, attribute.Int64("drone.build.number", args.Build.Number))
+	}
+	if args.Stage != nil {
+		attrs = append(attrs, attribute.String("drone.stage.name", args.Stage.Name))
+	}
+	return attrs
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/drone-runners/drone-runner-kube/engine/resource"
 	"github.com/drone/drone-go/drone"
 	"github.com/drone/runner-go/manifest"
+	"github.com/gosimple/slug"
 )
 
 // helper function returns true if the step is configured to
@@ -181,6 +182,16 @@ func firstNonZero(values ...int64) int64 {
 	return 0
 }
 
+// LabelValue returns the value as a valid kubernetes label value,
+// which is at most 63 characters long and ends with an alphanumeric.
+func LabelValue(s string) string {
+	s = slug.Make(s)
+	if len(s) > 63 {
+		s = strings.TrimRight(s[:63], "-")
+	}
+	return s
+}
+
 // list of restricted variables
 var restrictedVars = []string{
 	"XDG_RUNTIME_DIR",
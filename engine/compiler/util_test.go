@@ -5,6 +5,7 @@
 package compiler
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/drone-runners/drone-runner-kube/engine"
@@ -197,3 +198,18 @@ func Test_removeCloneDeps_CloneEnabled(t *testing.T) {
 		t.Log(diff)
 	}
 }
+
+func TestLabelValue(t *testing.T) {
+	tests := []struct {
+		in, out string
+	}{
+		{in: "Runner 1", out: "runner-1"},
+		{in: strings.Repeat("a", 62) + " b", out: strings.Repeat("a", 62)},
+		{in: strings.Repeat("a", 70), out: strings.Repeat("a", 63)},
+	}
+	for _, test := range tests {
+		if got, want := LabelValue(test.in), test.out; got != want {
+			t.Errorf("Want label value %q, got %q", want, got)
+		}
+	}
+}
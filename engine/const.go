@@ -9,6 +9,10 @@ import (
 	"encoding/json"
 )
 
+// SecretsPath is the directory where the secrets
+// provided as files are mounted in the step container.
+const SecretsPath = "/run/drone/secrets"
+
 var statusesWhiteList = []string{
 	"DRONE_BUILD_STATUS",
 	"DRONE_BUILD_FINISHED",
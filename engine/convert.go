@@ -5,7 +5,11 @@
 package engine
 
 import (
+	"encoding/json"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -32,10 +36,126 @@ func toPod(spec *Spec) *v1.Pod {
 			ImagePullSecrets:   toImagePullSecrets(spec),
 			HostAliases:        toHostAliases(spec),
 			DNSConfig:          toDnsConfig(spec),
+
+			Affinity:                  toAffinity(spec),
+			TopologySpreadConstraints: toTopologySpreadConstraints(spec),
 		},
 	}
 }
 
+func toAffinity(spec *Spec) *v1.Affinity {
+	src := spec.PodSpec.Affinity
+	if src == nil {
+		return nil
+	}
+
+	dst := &v1.Affinity{}
+	if src.NodeAffinity != nil {
+		dst.NodeAffinity = &v1.NodeAffinity{}
+		if len(src.NodeAffinity.Required) != 0 {
+			selector := &v1.NodeSelector{}
+			for _, term := range src.NodeAffinity.Required {
+				selector.NodeSelectorTerms = append(selector.NodeSelectorTerms, toNodeSelectorTerm(term))
+			}
+			dst.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = selector
+		}
+		for _, term := range src.NodeAffinity.Preferred {
+			dst.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(
+				dst.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, v1.PreferredSchedulingTerm{
+					Weight:     term.Weight,
+					Preference: toNodeSelectorTerm(term.Preference),
+				})
+		}
+	}
+	if src.PodAffinity != nil {
+		dst.PodAffinity = &v1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution:  toPodAffinityTerms(src.PodAffinity.Required),
+			PreferredDuringSchedulingIgnoredDuringExecution: toWeightedPodAffinityTerms(src.PodAffinity.Preferred),
+		}
+	}
+	if src.PodAntiAffinity != nil {
+		dst.PodAntiAffinity = &v1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution:  toPodAffinityTerms(src.PodAntiAffinity.Required),
+			PreferredDuringSchedulingIgnoredDuringExecution: toWeightedPodAffinityTerms(src.PodAntiAffinity.Preferred),
+		}
+	}
+	return dst
+}
+
+func toNodeSelectorTerm(term NodeSelectorTerm) v1.NodeSelectorTerm {
+	var dst v1.NodeSelectorTerm
+	for _, r := range term.MatchExpressions {
+		dst.MatchExpressions = append(dst.MatchExpressions, v1.NodeSelectorRequirement{
+			Key:      r.Key,
+			Operator: v1.NodeSelectorOperator(r.Operator),
+			Values:   r.Values,
+		})
+	}
+	for _, r := range term.MatchFields {
+		dst.MatchFields = append(dst.MatchFields, v1.NodeSelectorRequirement{
+			Key:      r.Key,
+			Operator: v1.NodeSelectorOperator(r.Operator),
+			Values:   r.Values,
+		})
+	}
+	return dst
+}
+
+func toPodAffinityTerms(terms []PodAffinityTerm) []v1.PodAffinityTerm {
+	var dst []v1.PodAffinityTerm
+	for _, term := range terms {
+		dst = append(dst, toPodAffinityTerm(term))
+	}
+	return dst
+}
+
+func toWeightedPodAffinityTerms(terms []WeightedPodAffinityTerm) []v1.WeightedPodAffinityTerm {
+	var dst []v1.WeightedPodAffinityTerm
+	for _, term := range terms {
+		dst = append(dst, v1.WeightedPodAffinityTerm{
+			Weight:          term.Weight,
+			PodAffinityTerm: toPodAffinityTerm(term.PodAffinityTerm),
+		})
+	}
+	return dst
+}
+
+func toPodAffinityTerm(term PodAffinityTerm) v1.PodAffinityTerm {
+	return v1.PodAffinityTerm{
+		LabelSelector: toLabelSelector(term.LabelSelector),
+		Namespaces:    term.Namespaces,
+		TopologyKey:   term.TopologyKey,
+	}
+}
+
+func toTopologySpreadConstraints(spec *Spec) []v1.TopologySpreadConstraint {
+	var constraints []v1.TopologySpreadConstraint
+	for _, c := range spec.PodSpec.TopologySpreadConstraints {
+		constraints = append(constraints, v1.TopologySpreadConstraint{
+			MaxSkew:           c.MaxSkew,
+			TopologyKey:       c.TopologyKey,
+			WhenUnsatisfiable: v1.UnsatisfiableConstraintAction(c.WhenUnsatisfiable),
+			LabelSelector:     toLabelSelector(c.LabelSelector),
+		})
+	}
+	return constraints
+}
+
+func toLabelSelector(selector *LabelSelector) *metav1.LabelSelector {
+	if selector == nil {
+		return nil
+	}
+	dst := &metav1.LabelSelector{MatchLabels: selector.MatchLabels}
+	for _, r := range selector.MatchExpressions {
+		dst.MatchExpressions = append(dst.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      r.Key,
+			Operator: metav1.LabelSelectorOperator(r.Operator),
+			Values:   r.Values,
+		})
+	}
+	return dst
+}
+
 func toDnsConfig(spec *Spec) *v1.PodDNSConfig {
 	var dnsOptions []v1.PodDNSConfigOption
 	if len(spec.PodSpec.DnsConfig.Options) > 0 {
@@ -87,6 +207,19 @@ func toTolerations(spec *Spec) []v1.Toleration {
 func toVolumes(spec *Spec) []v1.Volume {
 	var volumes []v1.Volume
 	for _, v := range spec.Volumes {
+		// the workspace and the temporary volumes are shared by the step pods through the claim.
+		if v.EmptyDir != nil && spec.workspaceClaim != "" {
+			volumes = append(volumes, v1.Volume{
+				Name: v.EmptyDir.ID,
+				VolumeSource: v1.VolumeSource{
+					PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+						ClaimName: spec.workspaceClaim,
+					},
+				},
+			})
+			continue
+		}
+
 		if v.EmptyDir != nil {
 			source := &v1.EmptyDirVolumeSource{}
 			if strings.EqualFold(v.EmptyDir.Medium, "memory") {
@@ -162,22 +295,11 @@ func toVolumes(spec *Spec) []v1.Volume {
 		}
 
 		if v.DownwardAPI != nil {
-			var items []v1.DownwardAPIVolumeFile
-
-			for _, item := range v.DownwardAPI.Items {
-				items = append(items, v1.DownwardAPIVolumeFile{
-					Path: item.Path,
-					FieldRef: &v1.ObjectFieldSelector{
-						FieldPath: item.FieldPath,
-					},
-				})
-			}
-
 			volume := v1.Volume{
 				Name: v.DownwardAPI.ID,
 				VolumeSource: v1.VolumeSource{
 					DownwardAPI: &v1.DownwardAPIVolumeSource{
-						Items: items,
+						Items: toDownwardAPIItems(v.DownwardAPI),
 					},
 				},
 			}
@@ -186,12 +308,21 @@ func toVolumes(spec *Spec) []v1.Volume {
 		}
 	}
 
+	for _, step := range append(spec.Internal, spec.Steps...) {
+		if volume := toSecretsVolume(spec, step); volume != nil {
+			volumes = append(volumes, *volume)
+		}
+	}
+
 	return volumes
 }
 
 func toContainers(spec *Spec) []v1.Container {
 	var containers []v1.Container
 	for _, s := range spec.Steps {
+		if isNativeSidecar(spec, s) {
+			continue // started as an init container, see toInitContainers
+		}
 		containers = append(containers, toContainer(s, spec))
 	}
 	return containers
@@ -204,9 +335,101 @@ func toInitContainers(spec *Spec) []v1.Container {
 		c.Image = s.Image
 		containers = append(containers, c)
 	}
+	// the native sidecars follow the internal steps, which must complete before the sidecars start.
+	for _, s := range spec.Steps {
+		if isNativeSidecar(spec, s) {
+			c := toContainer(s, spec)
+			c.Image = s.Image
+			containers = append(containers, c)
+		}
+	}
 	return containers
 }
 
+// toNativeSidecarPod returns the pod as JSON, with the restart policy of the native sidecar
+// containers set to Always. The field is missing from the Kubernetes API types this runner
+// is built with, so the pod's JSON is modified directly.
+func toNativeSidecarPod(spec *Spec) ([]byte, error) {
+	raw, err := json.Marshal(toPod(spec))
+	if err != nil {
+		return nil, err
+	}
+
+	var pod map[string]interface{}
+	if err := json.Unmarshal(raw, &pod); err != nil {
+		return nil, err
+	}
+
+	sidecars := make(map[string]struct{})
+	for _, s := range spec.Steps {
+		if isNativeSidecar(spec, s) {
+			sidecars[s.ID] = struct{}{}
+		}
+	}
+
+	podSpec, _ := pod["spec"].(map[string]interface{})
+	initContainers, _ := podSpec["initContainers"].([]interface{})
+	for _, c := range initContainers {
+		container, _ := c.(map[string]interface{})
+		name, _ := container["name"].(string)
+		if _, ok := sidecars[name]; ok {
+			container["restartPolicy"] = string(v1.RestartPolicyAlways)
+		}
+	}
+
+	return json.Marshal(pod)
+}
+
+// toDebugContainer returns the ephemeral container used to debug a failed step. It has the step's
+// volumes mounted, except the secrets. If target is true, it shares the step's process namespace,
+// which is only possible while the step's container is running.
+func toDebugContainer(spec *Spec, step *Step, name string, target bool) v1.EphemeralContainer {
+	var volumeMounts []v1.VolumeMount
+	for _, v := range step.Volumes {
+		id, ok := lookupVolumeID(spec, v.Name)
+		if !ok {
+			continue
+		}
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      id,
+			MountPath: v.Path,
+			SubPath:   toClaimSubPath(spec, v),
+			ReadOnly:  v.ReadOnly,
+		})
+	}
+
+	c := v1.EphemeralContainer{
+		EphemeralContainerCommon: v1.EphemeralContainerCommon{
+			Name:            name,
+			Image:           spec.Debug.Image,
+			Command:         []string{"/bin/sh"},
+			ImagePullPolicy: v1.PullIfNotPresent,
+			WorkingDir:      step.WorkingDir,
+			VolumeMounts:    volumeMounts,
+			Stdin:           true,
+			TTY:             true,
+		},
+	}
+	if target {
+		c.TargetContainerName = step.ID
+	}
+	// the shell exits, which ends the debug session, if it gets no input for the idle timeout.
+	if timeout := spec.Debug.IdleTimeout; timeout > 0 {
+		c.Env = []v1.EnvVar{{Name: "TMOUT", Value: strconv.Itoa(int((timeout + time.Second - 1) / time.Second))}}
+	}
+	return c
+}
+
+// toEphemeralContainerPatch returns a strategic merge patch that adds an ephemeral container to the pod.
+func toEphemeralContainerPatch(c v1.EphemeralContainer) []byte {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ephemeralContainers": []v1.EphemeralContainer{c},
+		},
+	})
+	return patch
+}
+
 func toContainer(s *Step, spec *Spec) v1.Container {
 	return v1.Container{
 		Name:            s.ID,
@@ -232,13 +455,18 @@ func toEnv(spec *Spec, step *Step) []v1.EnvVar {
 		})
 	}
 
+	secretName := toSecretName(spec, step)
+
 	for _, secret := range step.Secrets {
+		if secret.File {
+			continue // provided in the secrets volume, see toSecretsVolume
+		}
 		envVars = append(envVars, v1.EnvVar{
 			Name: secret.Env,
 			ValueFrom: &v1.EnvVarSource{
 				SecretKeyRef: &v1.SecretKeySelector{
 					LocalObjectReference: v1.LocalObjectReference{
-						Name: spec.PodSpec.Name,
+						Name: secretName,
 					},
 					Key:      secret.Name,
 					Optional: boolptr(true),
@@ -259,18 +487,94 @@ func toEnv(spec *Spec, step *Step) []v1.EnvVar {
 	return envVars
 }
 
-func toEnvFrom(step *Step) []v1.EnvFromSource {
-	return []v1.EnvFromSource{
+// toSecretName returns the name of the kubernetes secret holding the step's secrets.
+func toSecretName(spec *Spec, step *Step) string {
+	if spec.SecretPerStep {
+		return step.ID
+	}
+	return spec.PodSpec.Name
+}
+
+// toSecretsVolume returns the in-memory volume with the secrets
+// the step wants as files, or nil if there are no such secrets.
+// The secrets directory is in the status directory, which is read
+// only, so the volume holds the status files too and it is mounted
+// in place of the status volume, see toVolumeMounts.
+func toSecretsVolume(spec *Spec, step *Step) *v1.Volume {
+	var items []v1.KeyToPath
+	for _, secret := range step.Secrets {
+		if secret.File {
+			items = append(items, v1.KeyToPath{
+				Key:  secret.Name,
+				Path: path.Join(path.Base(SecretsPath), secret.Name),
+			})
+		}
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	sources := []v1.VolumeProjection{
 		{
-			SecretRef: &v1.SecretEnvSource{
+			Secret: &v1.SecretProjection{
 				LocalObjectReference: v1.LocalObjectReference{
-					Name: step.ID,
+					Name: toSecretName(spec, step),
 				},
+				Items:    items,
+				Optional: boolptr(true),
+			},
+		},
+	}
+
+	if status := lookupStatusVolume(spec, step); status != nil {
+		sources = append(sources, v1.VolumeProjection{
+			DownwardAPI: &v1.DownwardAPIProjection{
+				Items: toDownwardAPIItems(status),
+			},
+		})
+	}
+
+	return &v1.Volume{
+		Name: step.ID + "-secrets",
+		VolumeSource: v1.VolumeSource{
+			Projected: &v1.ProjectedVolumeSource{
+				Sources:     sources,
+				DefaultMode: int32ptr(0444),
 			},
 		},
 	}
 }
 
+// lookupStatusVolume returns the downward API volume the step mounts
+// in the parent directory of the secrets, or nil if there is none.
+func lookupStatusVolume(spec *Spec, step *Step) *VolumeDownwardAPI {
+	for _, m := range step.Volumes {
+		if m.Path != path.Dir(SecretsPath) {
+			continue
+		}
+		for _, v := range spec.Volumes {
+			if v.DownwardAPI != nil && v.DownwardAPI.Name == m.Name {
+				return v.DownwardAPI
+			}
+		}
+	}
+	return nil
+}
+
+func toDownwardAPIItems(src *VolumeDownwardAPI) []v1.DownwardAPIVolumeFile {
+	var items []v1.DownwardAPIVolumeFile
+	for _, item := range src.Items {
+		items = append(items, v1.DownwardAPIVolumeFile{
+			Path: item.Path,
+			FieldRef: &v1.ObjectFieldSelector{
+				FieldPath: item.FieldPath,
+			},
+		})
+	}
+	return items
+}
+
 func toSecret(spec *Spec) *v1.Secret {
 	stringData := make(map[string]string)
 	for _, secret := range spec.Secrets {
@@ -279,17 +583,58 @@ func toSecret(spec *Spec) *v1.Secret {
 
 	return &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: spec.PodSpec.Name,
+			Name:   spec.PodSpec.Name,
+			Labels: spec.PodSpec.Labels,
 		},
 		Type:       "Opaque",
 		StringData: stringData,
 	}
 }
 
+// toStepSecret returns the secret holding only the secrets used by the step.
+func toStepSecret(spec *Spec, step *Step) *v1.Secret {
+	stringData := make(map[string]string)
+	for _, secret := range step.Secrets {
+		if s, ok := spec.Secrets[secret.Name]; ok {
+			stringData[s.Name] = s.Data
+		}
+	}
+
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   step.ID,
+			Labels: spec.PodSpec.Labels,
+		},
+		Type:       "Opaque",
+		StringData: stringData,
+	}
+}
+
+// toSecrets returns all secrets that should be created for the pipeline.
+func toSecrets(spec *Spec) []*v1.Secret {
+	var secrets []*v1.Secret
+	if spec.PullSecret != nil {
+		secrets = append(secrets, toDockerConfigSecret(spec))
+	}
+
+	if !spec.SecretPerStep {
+		return append(secrets, toSecret(spec))
+	}
+
+	for _, step := range append(spec.Internal, spec.Steps...) {
+		if len(step.Secrets) > 0 {
+			secrets = append(secrets, toStepSecret(spec, step))
+		}
+	}
+
+	return secrets
+}
+
 func toDockerConfigSecret(spec *Spec) *v1.Secret {
 	return &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: spec.PullSecret.Name,
+			Name:   spec.PullSecret.Name,
+			Labels: spec.PodSpec.Labels,
 		},
 		Type: "kubernetes.io/dockerconfigjson",
 		StringData: map[string]string{
@@ -309,8 +654,15 @@ func toImagePullSecrets(spec *Spec) []v1.LocalObjectReference {
 }
 
 func toVolumeMounts(spec *Spec, step *Step) []v1.VolumeMount {
+	secretsVolume := toSecretsVolume(spec, step)
+
 	var volumeMounts []v1.VolumeMount
 	for _, v := range step.Volumes {
+		// the secrets volume holds the status files, it is mounted instead.
+		if secretsVolume != nil && v.Path == path.Dir(SecretsPath) {
+			continue
+		}
+
 		id, ok := lookupVolumeID(spec, v.Name)
 		if !ok {
 			continue
@@ -318,11 +670,19 @@ func toVolumeMounts(spec *Spec, step *Step) []v1.VolumeMount {
 		volumeMounts = append(volumeMounts, v1.VolumeMount{
 			Name:      id,
 			MountPath: v.Path,
-			SubPath:   v.SubPath,
+			SubPath:   toClaimSubPath(spec, v),
 			ReadOnly:  v.ReadOnly,
 		})
 	}
 
+	if secretsVolume != nil {
+		volumeMounts = append(volumeMounts, v1.VolumeMount{
+			Name:      secretsVolume.Name,
+			MountPath: path.Dir(SecretsPath),
+			ReadOnly:  true,
+		})
+	}
+
 	return volumeMounts
 }
 
@@ -372,6 +732,24 @@ func toSecurityContext(s *Step) *v1.SecurityContext {
 	}
 }
 
+// toClaimSubPath returns the sub path of the volume mount. If the temporary volumes are backed
+// by the workspace claim, each volume is a separate directory of the claim.
+func toClaimSubPath(spec *Spec, mount *VolumeMount) string {
+	if spec.workspaceClaim == "" {
+		return mount.SubPath
+	}
+	for _, v := range spec.Volumes {
+		if v.EmptyDir == nil || v.EmptyDir.Name != mount.Name {
+			continue
+		}
+		if v.EmptyDir.Name == workspaceVolumeName {
+			return path.Join("workspace", mount.SubPath)
+		}
+		return path.Join("volumes", v.EmptyDir.Name, mount.SubPath)
+	}
+	return mount.SubPath
+}
+
 // LookupVolume is a helper function that will lookup
 // the id for a volume.
 func lookupVolumeID(spec *Spec, name string) (string, bool) {
@@ -417,6 +795,10 @@ func toPullPolicy(policy PullPolicy) v1.PullPolicy {
 	}
 }
 
+func int32ptr(v int32) *int32 {
+	return &v
+}
+
 func int64ptr(v int64) *int64 {
 	return &v
 }
@@ -428,3 +810,81 @@ func boolptr(v bool) *bool {
 func stringptr(v string) *string {
 	return &v
 }
+
+// toOwnerPatch returns a merge patch that sets the owner of a resource.
+func toOwnerPatch(owner metav1.OwnerReference) []byte {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"ownerReferences": []metav1.OwnerReference{owner},
+		},
+	})
+	return patch
+}
+
+func toOwnerReference(pod *v1.Pod) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}
+}
+
+func toClaimOwnerReference(claim *v1.PersistentVolumeClaim) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "PersistentVolumeClaim",
+		Name:       claim.Name,
+		UID:        claim.UID,
+	}
+}
+
+// toWorkspaceClaim returns the persistent volume claim holding the workspace of a pipeline run by the PodPerStep engine.
+func toWorkspaceClaim(spec *Spec, workspace WorkspaceClaim) *v1.PersistentVolumeClaim {
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   spec.PodSpec.Name,
+			Labels: spec.PodSpec.Labels,
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{workspace.AccessMode},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceStorage: workspace.Size,
+				},
+			},
+		},
+	}
+	if workspace.StorageClass != "" {
+		claim.Spec.StorageClassName = stringptr(workspace.StorageClass)
+	}
+	return claim
+}
+
+// toStepPod returns the pod of a step run by the PodPerStep engine. The spec is the pipeline's spec
+// reduced to the step. The workspace and the temporary volumes are mounted from the pipeline's claim,
+// see toVolumes, and if the claim can be mounted only on a single node, the pod is run on the same
+// node as the other pods of the pipeline. The PodPerStep engine also pins the pods to the node of the
+// first step pod; the affinity still applies if that pod isn't scheduled in time.
+func toStepPod(spec *Spec, stage string, workspace WorkspaceClaim) *v1.Pod {
+	pod := toPod(spec)
+
+	// the term is added to the pipeline's affinity.
+	if workspace.AccessMode == v1.ReadWriteOnce {
+		if pod.Spec.Affinity == nil {
+			pod.Spec.Affinity = &v1.Affinity{}
+		}
+		if pod.Spec.Affinity.PodAffinity == nil {
+			pod.Spec.Affinity.PodAffinity = &v1.PodAffinity{}
+		}
+		pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(
+			pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution, v1.PodAffinityTerm{
+				LabelSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"io.drone.name": stage},
+				},
+				TopologyKey: labelHostname,
+			})
+	}
+
+	return pod
+}
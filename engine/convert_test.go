@@ -5,8 +5,15 @@
 package engine
 
 import (
-	v1 "k8s.io/api/core/v1"
+	"encoding/json"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/drone/runner-go/pipeline/runtime"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestSecurityContext(t *testing.T) {
@@ -29,4 +36,250 @@ func TestSecurityContext(t *testing.T) {
 	if failed {
 		t.Error("security context was not converted to expected values")
 	}
-}
\ No newline at end of file
+}
+
+func TestToSecrets_PerStep(t *testing.T) {
+	spec := &Spec{
+		Secrets: map[string]*Secret{
+			"token":    {Name: "token", Data: "secret-token"},
+			"password": {Name: "password", Data: "secret-password"},
+		},
+		SecretPerStep: true,
+		Steps: []*Step{
+			{ID: "step-a", Secrets: []*SecretVar{{Name: "token", Env: "TOKEN"}}},
+			{ID: "step-b", Secrets: []*SecretVar{{Name: "password", Env: "PASSWORD"}}},
+			{ID: "step-c"},
+		},
+	}
+	spec.PodSpec.Name = "pod"
+
+	secrets := toSecrets(spec)
+	if len(secrets) != 2 {
+		t.Fatalf("expected a secret for each step with secrets, got %d", len(secrets))
+	}
+
+	if got := secrets[0]; got.Name != "step-a" || len(got.StringData) != 1 || got.StringData["token"] != "secret-token" {
+		t.Errorf("unexpected secret of step-a: %v", got)
+	}
+
+	if got := secrets[1]; got.Name != "step-b" || len(got.StringData) != 1 || got.StringData["password"] != "secret-password" {
+		t.Errorf("unexpected secret of step-b: %v", got)
+	}
+
+	for _, env := range toEnv(spec, spec.Steps[0]) {
+		if env.Name != "TOKEN" {
+			continue
+		}
+		if ref := env.ValueFrom.SecretKeyRef; ref.Name != "step-a" || ref.Key != "token" {
+			t.Errorf("expected the step to reference its own secret, got %v", ref)
+		}
+	}
+}
+
+func TestSecretsAsFiles(t *testing.T) {
+	status := &VolumeDownwardAPI{
+		ID:    "status",
+		Name:  "_status",
+		Items: []VolumeDownwardAPIItem{{Path: "env", FieldPath: "metadata.annotations"}},
+	}
+	step := &Step{
+		ID: "step",
+		Secrets: []*SecretVar{
+			{Name: "token", Env: "TOKEN", File: true},
+			{Name: "username", Env: "USERNAME"},
+		},
+		Volumes: []*VolumeMount{{Name: "_status", Path: "/run/drone"}},
+	}
+	spec := &Spec{Steps: []*Step{step}, Volumes: []*Volume{{DownwardAPI: status}}}
+	spec.PodSpec.Name = "pod"
+
+	for _, env := range toEnv(spec, step) {
+		if env.Name == "TOKEN" {
+			t.Errorf("expected the secret provided as a file not to be in the environment")
+		}
+	}
+
+	volumes := toVolumes(spec)
+	if len(volumes) != 2 || volumes[1].Projected == nil {
+		t.Fatalf("expected the status and the secrets volume, got %v", volumes)
+	}
+
+	want := []v1.VolumeProjection{
+		{
+			Secret: &v1.SecretProjection{
+				LocalObjectReference: v1.LocalObjectReference{Name: "pod"},
+				Items:                []v1.KeyToPath{{Key: "token", Path: "secrets/token"}},
+				Optional:             boolptr(true),
+			},
+		},
+		{
+			DownwardAPI: &v1.DownwardAPIProjection{
+				Items: []v1.DownwardAPIVolumeFile{
+					{Path: "env", FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.annotations"}},
+				},
+			},
+		},
+	}
+	if got := volumes[1].Projected.Sources; !reflect.DeepEqual(got, want) {
+		t.Errorf("want secrets volume sources %v, got %v", want, got)
+	}
+
+	// the secrets volume holds the status files, it is mounted in place of the status volume.
+	mounts := toVolumeMounts(spec, step)
+	if len(mounts) != 1 || mounts[0].Name != volumes[1].Name || mounts[0].MountPath != "/run/drone" || !mounts[0].ReadOnly {
+		t.Errorf("expected the secrets volume to be mounted read only at /run/drone, got %v", mounts)
+	}
+}
+
+func TestToNativeSidecarPod(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{ID: "clone", Image: "drone/git", Placeholder: "drone/placeholder:1"},
+			{ID: "database", Image: "mysql", Placeholder: "drone/placeholder:1", Detach: true, Service: true},
+			{ID: "skipped", Image: "redis", Placeholder: "drone/placeholder:1", Detach: true, Service: true, RunPolicy: runtime.RunNever},
+		},
+		nativeSidecars: true,
+	}
+	spec.PodSpec.Name = "pod"
+
+	raw, err := toNativeSidecarPod(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pod struct {
+		Spec struct {
+			InitContainers []struct {
+				Name          string `json:"name"`
+				Image         string `json:"image"`
+				RestartPolicy string `json:"restartPolicy"`
+			} `json:"initContainers"`
+			Containers []struct {
+				Name string `json:"name"`
+			} `json:"containers"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(raw, &pod); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pod.Spec.InitContainers) != 1 {
+		t.Fatalf("expected one init container, got %+v", pod.Spec.InitContainers)
+	}
+	if c := pod.Spec.InitContainers[0]; c.Name != "database" || c.Image != "mysql" || c.RestartPolicy != "Always" {
+		t.Errorf("expected the service to be a native sidecar with its own image, got %+v", c)
+	}
+
+	if len(pod.Spec.Containers) != 2 || pod.Spec.Containers[0].Name != "clone" || pod.Spec.Containers[1].Name != "skipped" {
+		t.Errorf("expected the step and the skipped service to be regular containers, got %+v", pod.Spec.Containers)
+	}
+}
+
+func TestToDebugContainer(t *testing.T) {
+	step := &Step{
+		ID:         "step",
+		WorkingDir: "/drone/src",
+		Volumes: []*VolumeMount{
+			{Name: "_workspace", Path: "/drone/src"},
+		},
+		Secrets: []*SecretVar{
+			{Name: "token", Env: "TOKEN", File: true},
+		},
+	}
+	spec := &Spec{
+		Steps:   []*Step{step},
+		Volumes: []*Volume{{EmptyDir: &VolumeEmptyDir{ID: "workspace", Name: "_workspace"}}},
+		Debug:   &Debug{Image: "busybox:1"},
+	}
+
+	c := toDebugContainer(spec, step, "debug-step", false)
+	if c.Image != "busybox:1" || c.TargetContainerName != "" || !c.Stdin || !c.TTY {
+		t.Errorf("unexpected debug container: %+v", c)
+	}
+
+	want := []v1.VolumeMount{{Name: "workspace", MountPath: "/drone/src"}}
+	if !reflect.DeepEqual(c.VolumeMounts, want) {
+		t.Errorf("expected only the workspace to be mounted, got %v", c.VolumeMounts)
+	}
+
+	if c := toDebugContainer(spec, step, "debug-step", true); c.TargetContainerName != "step" {
+		t.Errorf("expected the debug container to target the step, got %q", c.TargetContainerName)
+	}
+
+	// the shell exits after the idle timeout.
+	spec.Debug.IdleTimeout = 90 * time.Second
+	c = toDebugContainer(spec, step, "debug-step", false)
+	if want := []v1.EnvVar{{Name: "TMOUT", Value: "90"}}; !reflect.DeepEqual(c.Env, want) {
+		t.Errorf("want the idle timeout of the shell %v, got %v", want, c.Env)
+	}
+}
+
+func TestToAffinity(t *testing.T) {
+	spec := &Spec{
+		PodSpec: PodSpec{
+			Affinity: &Affinity{
+				NodeAffinity: &NodeAffinity{
+					Required: []NodeSelectorTerm{{
+						MatchExpressions: []SelectorRequirement{{Key: "gpu", Operator: "Exists"}},
+					}},
+				},
+				PodAntiAffinity: &PodAffinity{
+					Preferred: []WeightedPodAffinityTerm{{
+						Weight: 100,
+						PodAffinityTerm: PodAffinityTerm{
+							LabelSelector: &LabelSelector{MatchLabels: map[string]string{"io.drone.repo.name": "heavy"}},
+							TopologyKey:   "kubernetes.io/hostname",
+						},
+					}},
+				},
+			},
+			TopologySpreadConstraints: []TopologySpreadConstraint{{
+				MaxSkew:           1,
+				TopologyKey:       "topology.kubernetes.io/zone",
+				WhenUnsatisfiable: "ScheduleAnyway",
+			}},
+		},
+	}
+
+	pod := toPod(spec)
+
+	want := &v1.Affinity{
+		NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{
+					MatchExpressions: []v1.NodeSelectorRequirement{{Key: "gpu", Operator: v1.NodeSelectorOpExists}},
+				}},
+			},
+		},
+		PodAntiAffinity: &v1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{{
+				Weight: 100,
+				PodAffinityTerm: v1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"io.drone.repo.name": "heavy"}},
+					TopologyKey:   "kubernetes.io/hostname",
+				},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(pod.Spec.Affinity, want) {
+		t.Errorf("Unexpected affinity %+v", pod.Spec.Affinity)
+	}
+
+	wantConstraints := []v1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       "topology.kubernetes.io/zone",
+		WhenUnsatisfiable: v1.ScheduleAnyway,
+	}}
+	if !reflect.DeepEqual(pod.Spec.TopologySpreadConstraints, wantConstraints) {
+		t.Errorf("Unexpected topology spread constraints %+v", pod.Spec.TopologySpreadConstraints)
+	}
+
+	// the pod of a step keeps the pipeline's affinity, and is run on the node of the workspace claim.
+	pod = toStepPod(spec, "drone-stage", WorkspaceClaim{AccessMode: v1.ReadWriteOnce})
+	if pod.Spec.Affinity.NodeAffinity == nil || pod.Spec.Affinity.PodAntiAffinity == nil {
+		t.Errorf("Expected the step pod to keep the pipeline's affinity")
+	}
+	if pod.Spec.Affinity.PodAffinity == nil || len(pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 1 {
+		t.Errorf("Expected the step pod to be run on the node of the workspace claim")
+	}
+}
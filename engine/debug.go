@@ -0,0 +1,96 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine/podwatcher"
+
+	"github.com/drone/runner-go/logger"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// debugContainerPrefix is the prefix of the debug container's name, followed by the step's container name.
+const debugContainerPrefix = "debug-"
+
+// debugStep adds an ephemeral debug container to the pod of a failed step, writes the command to attach to
+// it to the step's output, and waits until the debug session ends. The debug shell exits if it's idle for the
+// idle timeout, see toDebugContainer. The pod is kept alive meanwhile, because the pipeline doesn't continue
+// until the step is finished. If target is true, the debug container shares the process namespace of the step's
+// container, which must still be running.
+func (k *Kubernetes) debugStep(ctx context.Context, spec *Spec, step *Step, watcher *podwatcher.PodWatcher, output io.Writer, target bool, log logger.Logger) {
+	name := debugContainerPrefix + step.ID
+	container := toDebugContainer(spec, step, name, target)
+
+	log = log.WithField("debug_container", name)
+
+	reqCtx, cancel := context.WithTimeout(ctx, execTimeout)
+	err := k.client.CoreV1().RESTClient().Patch(types.StrategicMergePatchType).
+		Namespace(spec.PodSpec.Namespace).
+		Resource("pods").
+		Name(spec.PodSpec.Name).
+		SubResource("ephemeralcontainers").
+		Body(toEphemeralContainerPatch(container)).
+		Do(reqCtx).
+		Error()
+	cancel()
+	if err != nil {
+		log.WithError(err).Error("Engine: Failed to add debug container")
+		_, _ = io.WriteString(output, fmt.Sprintf("Failed to add a debug container: %s\n", err))
+		return
+	}
+
+	if err = watcher.AddContainer(name, "", container.Image); err != nil {
+		log.WithError(err).Error("Engine: Failed to watch debug container")
+		return
+	}
+
+	chErrStart := make(chan error, 1)
+	go func() {
+		chErrStart <- watcher.WaitContainerStart(name)
+	}()
+
+	select {
+	case err = <-chErrStart:
+	case <-time.After(k.containerStartTimeout):
+		err = podwatcher.StartTimeoutContainerError{Container: name, Image: container.Image}
+	case <-ctx.Done():
+		return
+	case <-spec.stop:
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("Engine: Debug container failed to start")
+		_, _ = io.WriteString(output, fmt.Sprintf("Debug container failed to start: %s\n", err))
+		return
+	}
+
+	_, _ = io.WriteString(output, fmt.Sprintf(
+		"Step %q failed. The pod is kept until the debug session ends, or the debug shell is idle for %s. Attach to the debug container with:\n"+
+			"kubectl attach -it -n %s %s -c %s\n",
+		step.Name, spec.Debug.IdleTimeout, spec.PodSpec.Namespace, spec.PodSpec.Name, name))
+
+	log.Debug("Engine: Debug container started")
+
+	chErrStop := make(chan error, 1)
+	go func() {
+		_, err := watcher.WaitContainerTerminated(name)
+		chErrStop <- err
+	}()
+
+	// the build timeout applies, the debug session ends if the build is canceled or timed out.
+	select {
+	case <-chErrStop:
+		log.Debug("Engine: Debug session ended")
+		_, _ = io.WriteString(output, "Debug session ended\n")
+	case <-ctx.Done():
+	case <-spec.stop:
+	}
+}
@@ -24,21 +24,8 @@ func Dump(w io.Writer, spec *Spec) {
 	// Secret Encoding.
 	//
 
-	{
-		io.WriteString(w, documentBegin)
-		res := toSecret(spec)
-		res.Kind = "Secret"
-		raw, _ := yaml.Marshal(res)
-		w.Write(raw)
-	}
-
-	//
-	// Pull Secret Encoding.
-	//
-
-	if spec.PullSecret != nil {
+	for _, res := range toSecrets(spec) {
 		io.WriteString(w, documentBegin)
-		res := toDockerConfigSecret(spec)
 		res.Kind = "Secret"
 		raw, _ := yaml.Marshal(res)
 		w.Write(raw)
@@ -7,6 +7,7 @@ package engine
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
 	"time"
@@ -17,37 +18,84 @@ import (
 	"github.com/drone/runner-go/logger"
 	"github.com/drone/runner-go/pipeline/runtime"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 )
 
 // Kubernetes implements a Kubernetes pipeline engine.
 type Kubernetes struct {
 	client    kubernetes.Interface
+	config    *rest.Config
+	informer  *podwatcher.PodInformer
+	stages    *sync.Map
 	watchers  *sync.Map
 	launchers *sync.Map
+	streams   *sync.Map
+	events    *sync.Map
+
+	containerStartTimeout time.Duration
+	podScheduleTimeout    time.Duration
+	logStreamTimeout      time.Duration
+
+	// nativeSidecars enables running the services as native sidecar containers
+	// if the cluster supports them. The support is detected once and cached.
+	nativeSidecars   bool
+	sidecarSupportMx sync.Mutex
+	sidecarSupport   *bool
+}
+
+// exitCodeTimeout is the exit code reported for a step that exceeded its timeout,
+// the same exit code is used by the coreutils timeout command.
+const exitCodeTimeout = 124
+
+// logStreamRetryDelay is the time to wait before a broken log stream is reopened.
+const logStreamRetryDelay = time.Second
 
-	containerStartTimeout      time.Duration
-	containerTimeToWaitForLogs time.Duration // HACK: this timeout delays fetching the logs to ensure there is enough time to stream the logs.
+// streamKey identifies an in-flight log stream of a container.
+type streamKey struct {
+	pod       string
+	container string
 }
 
 var errPodStopped = errors.New("pod has been stopped")
 
-// New returns a new engine with the provided kubernetes client
-func New(client kubernetes.Interface, containerStartTimeout, containerTimeToWaitForLogs time.Duration) runtime.Engine {
+// New returns a new engine with the provided kubernetes client. The client's
+// configuration is needed to execute commands in containers, if it's nil
+// the containers of the canceled steps are stopped forcefully. The optional
+// pod informer is used to watch the pipeline pods in the namespaces it covers,
+// the pods in other namespaces are watched individually. If nativeSidecars is
+// true, the services are run as native sidecar containers on clusters that
+// support them.
+func New(client kubernetes.Interface, config *rest.Config, informer *podwatcher.PodInformer, containerStartTimeout, podScheduleTimeout, logStreamTimeout time.Duration, nativeSidecars bool) *Kubernetes {
 	if containerStartTimeout < time.Second {
 		containerStartTimeout = time.Second
 	}
 
+	if podScheduleTimeout < time.Second {
+		podScheduleTimeout = time.Second
+	}
+
 	return &Kubernetes{
 		client:    client,
+		config:    config,
+		informer:  informer,
+		stages:    &sync.Map{},
 		watchers:  &sync.Map{},
 		launchers: &sync.Map{},
+		streams:   &sync.Map{},
+		events:    &sync.Map{},
 
-		containerStartTimeout:      containerStartTimeout,
-		containerTimeToWaitForLogs: containerTimeToWaitForLogs,
+		containerStartTimeout: containerStartTimeout,
+		podScheduleTimeout:    podScheduleTimeout,
+		logStreamTimeout:      logStreamTimeout,
+		nativeSidecars:        nativeSidecars,
 	}
 }
 
@@ -55,45 +103,48 @@ func New(client kubernetes.Interface, containerStartTimeout, containerTimeToWait
 func (k *Kubernetes) Setup(ctx context.Context, specv runtime.Spec) (err error) {
 	spec := specv.(*Spec)
 
+	ctx, span := startStageSpan(ctx, spec, "Setup")
+	defer func() { endSpan(span, err) }()
+
 	log := logger.FromContext(ctx).
 		WithField("pod", spec.PodSpec.Name).
 		WithField("namespace", spec.PodSpec.Namespace)
 
-	if spec.Namespace != "" {
-		namespace := toNamespace(spec.Namespace, spec.PodSpec.Labels)
-		_, err = k.client.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{})
-		if err != nil {
-			log.WithError(err).Error("failed to create namespace")
-			return err
-		}
-		log.Trace("created namespace")
-	}
+	// the pipeline is marked as active before any resource is created,
+	// so that the resources are never considered orphaned.
+	k.stages.Store(spec.PodSpec.Name, struct{}{})
+	stagesRunning.Inc()
 
-	if spec.PullSecret != nil {
-		pullSecret := toDockerConfigSecret(spec)
-		_, err = k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Create(ctx, pullSecret, metav1.CreateOptions{})
-		if err != nil {
-			log.WithError(err).Error("failed to create pull secret")
-			return err
-		}
-		log.Trace("created pull secret")
+	if err = k.createNamespace(ctx, spec, log); err != nil {
+		return err
 	}
 
-	secret := toSecret(spec)
-	_, err = k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Create(ctx, secret, metav1.CreateOptions{})
-	if err != nil {
-		log.WithError(err).Error("failed to create secret")
+	secrets := toSecrets(spec)
+	if err = k.createSecrets(ctx, spec.PodSpec.Namespace, secrets, log); err != nil {
 		return err
 	}
-	log.Trace("created secret")
 
-	_, err = k.client.CoreV1().Pods(spec.PodSpec.Namespace).Create(ctx, toPod(spec), metav1.CreateOptions{})
+	// the server version is read only if there are services to run as native sidecars.
+	spec.nativeSidecars = hasServices(spec) && k.supportsNativeSidecars(log)
+
+	var pod *v1.Pod
+	if spec.nativeSidecars {
+		pod, err = k.createNativeSidecarPod(ctx, spec)
+	} else {
+		pod, err = k.client.CoreV1().Pods(spec.PodSpec.Namespace).Create(ctx, toPod(spec), metav1.CreateOptions{})
+	}
 	if err != nil {
 		log.WithError(err).Error("failed to create pod")
+		k.deleteSecrets(spec.PodSpec.Namespace, secrets, log)
 		return err
 	}
 	log.Trace("created pod")
 
+	// the secrets are created before the pod because the pod's containers reference them.
+	// once the pod exists it becomes the owner of the secrets, so kubernetes garbage collector
+	// deletes them together with the pod, even if the runner terminates before Destroy is called.
+	k.setSecretsOwner(ctx, spec, secrets, toOwnerReference(pod), log)
+
 	spec.stop = make(chan struct{})
 
 	return nil
@@ -101,34 +152,104 @@ func (k *Kubernetes) Setup(ctx context.Context, specv runtime.Spec) (err error)
 
 // Destroy the pipeline environment.
 func (k *Kubernetes) Destroy(ctx context.Context, specv runtime.Spec) error {
-	// HACK: this timeout delays deleting the Pod to ensure
-	// there is enough time to stream the logs.
-	time.Sleep(time.Second * 5)
-
 	spec := specv.(*Spec)
 
+	ctx, span := startStageSpan(ctx, spec, "Destroy")
+	defer span.End()
+
 	log := logger.FromContext(ctx).
 		WithField("pod", spec.PodSpec.Name).
 		WithField("namespace", spec.PodSpec.Namespace)
 
-	if spec.PullSecret != nil {
-		if err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Delete(context.Background(), spec.PullSecret.Name, metav1.DeleteOptions{}); err != nil {
-			log.WithError(err).Error("failed to delete pull secret")
-		} else {
-			log.Trace("deleted pull secret")
-		}
+	// wait for the log streams to finish before the pod is deleted,
+	// otherwise the tail of the step logs could be cut off.
+	if n := k.waitLogStreams(spec.PodSpec.Name); n > 0 {
+		log.WithField("streams", n).Warn("timeout waiting for log streams to finish")
+	} else {
+		log.Trace("log streams finished")
 	}
 
-	if err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Delete(context.Background(), spec.PodSpec.Name, metav1.DeleteOptions{}); err != nil {
-		log.WithError(err).Error("failed to delete secret")
+	// the secrets are owned by the pod and get deleted with it,
+	// unless the owner couldn't be set.
+	k.deleteSecrets(spec.PodSpec.Namespace, spec.unownedSecrets, log)
+
+	if spec.stop != nil {
+		close(spec.stop)
+	}
+
+	k.releasePod(spec, log)
+	k.deleteNamespace(spec, log)
+	k.endStage(spec)
+
+	return nil
+}
+
+// createNamespace creates the pipeline's namespace, if the pipeline runs in its own namespace.
+func (k *Kubernetes) createNamespace(ctx context.Context, spec *Spec, log logger.Logger) error {
+	if spec.Namespace == "" {
+		return nil
+	}
+
+	namespace := toNamespace(spec.Namespace, spec.PodSpec.Labels)
+	if _, err := k.client.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{}); err != nil {
+		log.WithError(err).Error("failed to create namespace")
+		return err
+	}
+	log.Trace("created namespace")
+
+	return nil
+}
+
+// deleteNamespace deletes the pipeline's namespace, if the pipeline runs in its own namespace.
+func (k *Kubernetes) deleteNamespace(spec *Spec, log logger.Logger) {
+	if spec.Namespace == "" {
+		return
+	}
+
+	if err := k.client.CoreV1().Namespaces().Delete(context.Background(), spec.Namespace, metav1.DeleteOptions{}); err != nil {
+		log.WithError(err).Error("failed to delete namespace")
 	} else {
-		log.Trace("deleted secret")
+		log.Trace("deleted namespace")
 	}
+}
 
-	close(spec.stop)
+// createSecrets creates the secrets of a pipeline. If a secret can't be created, the already created ones are deleted.
+func (k *Kubernetes) createSecrets(ctx context.Context, namespace string, secrets []*v1.Secret, log logger.Logger) error {
+	for i, secret := range secrets {
+		_, err := k.client.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+		if err != nil {
+			log.WithError(err).WithField("secret", secret.Name).Error("failed to create secret")
+			k.deleteSecrets(namespace, secrets[:i], log)
+			return err
+		}
+		log.WithField("secret", secret.Name).Trace("created secret")
+	}
+	return nil
+}
+
+// setSecretsOwner sets the owner of the secrets, so that kubernetes garbage collector deletes them
+// together with the owner. If the owner can't be set, for example because the runner isn't allowed
+// to patch secrets, the pipeline runs anyway and the secrets are deleted by Destroy.
+func (k *Kubernetes) setSecretsOwner(ctx context.Context, spec *Spec, secrets []*v1.Secret, owner metav1.OwnerReference, log logger.Logger) {
+	patch := toOwnerPatch(owner)
+	for _, secret := range secrets {
+		_, err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Patch(ctx, secret.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			log.WithError(err).WithField("secret", secret.Name).Warn("failed to set owner of secret, the secrets are deleted at the end of the pipeline")
+			spec.unownedSecrets = secrets
+			return
+		}
+	}
+	log.Trace("set owner of secrets")
+}
 
+// releasePod shuts down the service mesh proxies of the pod, deletes the pod,
+// and stops the launcher and the watchers of the pod.
+func (k *Kubernetes) releasePod(spec *Spec, log logger.Logger) {
 	var isPodDeleted bool
 
+	k.shutdownSidecars(spec, log)
+
 	if err := k.client.CoreV1().Pods(spec.PodSpec.Namespace).Delete(context.Background(), spec.PodSpec.Name, metav1.DeleteOptions{}); err != nil {
 		log.WithError(err).Error("failed to delete pod")
 	} else {
@@ -136,19 +257,15 @@ func (k *Kubernetes) Destroy(ctx context.Context, specv runtime.Spec) error {
 		isPodDeleted = true
 	}
 
-	if spec.Namespace != "" {
-		if err := k.client.CoreV1().Namespaces().Delete(context.Background(), spec.Namespace, metav1.DeleteOptions{}); err != nil {
-			log.WithError(err).Error("failed to delete namespace")
-		} else {
-			log.Trace("deleted namespace")
-		}
-	}
-
 	if _l, loaded := k.launchers.LoadAndDelete(spec.PodSpec.Name); loaded {
 		l := _l.(*launcher.Launcher)
 		l.Stop()
 	}
 
+	if ew, loaded := k.events.LoadAndDelete(spec.PodSpec.Name); loaded {
+		ew.(*podwatcher.EventWatcher).Stop()
+	}
+
 	if w, loaded := k.watchers.LoadAndDelete(spec.PodSpec.Name); loaded {
 		if isPodDeleted {
 			watcher := w.(*podwatcher.PodWatcher)
@@ -159,8 +276,35 @@ func (k *Kubernetes) Destroy(ctx context.Context, specv runtime.Spec) error {
 			}
 		}
 	}
+}
 
-	return nil
+// endStage marks the pipeline as no longer active.
+func (k *Kubernetes) endStage(spec *Spec) {
+	if _, loaded := k.stages.LoadAndDelete(spec.PodSpec.Name); loaded {
+		stagesRunning.Dec()
+		stagesFinished.Inc()
+	}
+}
+
+// deleteSecrets deletes the secrets of a pipeline which pod couldn't be set up.
+// Without the pod the secrets have no owner and would not be garbage collected.
+func (k *Kubernetes) deleteSecrets(namespace string, secrets []*v1.Secret, log logger.Logger) {
+	for _, secret := range secrets {
+		err := k.client.CoreV1().Secrets(namespace).Delete(context.Background(), secret.Name, metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			log.WithError(err).WithField("secret", secret.Name).Error("failed to delete secret")
+		} else {
+			log.WithField("secret", secret.Name).Trace("deleted secret")
+		}
+	}
+}
+
+// IsActive returns true if the pod belongs to a pipeline that is
+// currently running, i.e. the pipeline environment is set up, but
+// not yet destroyed.
+func (k *Kubernetes) IsActive(podName string) bool {
+	_, ok := k.stages.Load(podName)
+	return ok
 }
 
 // Run runs the pipeline step.
@@ -183,15 +327,37 @@ func (k *Kubernetes) Run(ctx context.Context, specv runtime.Spec, stepv runtime.
 		WithField("container", containerId).
 		WithField("step", stepName)
 
+	ctx, span := startStageSpan(ctx, spec, "Run")
+	span.SetAttributes(attribute.String("drone.step.name", stepName))
+
+	stepsRunning.Inc()
+	defer func() {
+		stepsRunning.Dec()
+		stepsFinished.WithLabelValues(stepResult(state, err)).Inc()
+		podwatcher.CountError(err)
+
+		if state != nil {
+			span.SetAttributes(attribute.Int("drone.step.exit_code", state.ExitCode))
+		}
+		endSpan(span, err)
+	}()
+
 	w, loaded := k.watchers.LoadOrStore(podId, &podwatcher.PodWatcher{})
 	watcher := w.(*podwatcher.PodWatcher)
 	if !loaded {
-		watcher.Start(context.Background(), &podwatcher.KubernetesWatcher{
-			PodNamespace: podNamespace,
-			PodName:      podId,
-			KubeClient:   k.client,
-			Period:       20 * time.Second,
-		})
+		var cw podwatcher.ContainerWatcher
+		if k.informer != nil && k.informer.Covers(podNamespace) {
+			cw = k.informer.Watcher(podNamespace, podId, 20*time.Second)
+		} else {
+			cw = &podwatcher.KubernetesWatcher{
+				PodNamespace: podNamespace,
+				PodName:      podId,
+				KubeClient:   k.client,
+				Period:       20 * time.Second,
+			}
+		}
+
+		watcher.Start(context.Background(), cw)
 
 		log.Trace("PodWatcher started")
 	}
@@ -201,46 +367,98 @@ func (k *Kubernetes) Run(ctx context.Context, specv runtime.Spec, stepv runtime.
 		return
 	}
 
+	// the step's secret is deleted however the step ends, except if the step's container is
+	// stopped in the background, which deletes the secret once the container is terminated.
+	var stopping bool
+	defer func() {
+		if !stopping {
+			k.deleteStepSecret(spec, step, log)
+		}
+	}()
+
 	log.Debug("Engine: Starting step")
 
-	err = <-k.startContainer(ctx, spec, step)
-	if err != nil {
-		return
-	}
+	// while the step is starting, the kubernetes events related to it are written to the step output.
+	events := k.startEventWatcher(spec)
+	events.Register(containerId, output)
 
-	chErrStart := make(chan error)
-	go func() {
-		chErrStart <- watcher.WaitContainerStart(containerId)
-	}()
+	launched := time.Now()
 
-	select {
-	case err = <-chErrStart:
-	case <-time.After(k.containerStartTimeout):
-		err = podwatcher.StartTimeoutContainerError{Container: containerId, Image: containerImage}
-		log.WithError(err).Error("Engine: Container start timeout")
-	case <-spec.stop:
-		return nil, errPodStopped
-	}
-	if err != nil {
-		return
+	// a native sidecar is started by kubernetes before the pod's containers, it isn't launched.
+	if !isNativeSidecar(spec, step) {
+		_, launchSpan := tracer.Start(ctx, "Launch")
+		err = <-k.startContainer(ctx, spec, step)
+		endSpan(launchSpan, err)
+		if err != nil {
+			events.Unregister(containerId)
+			return
+		}
 	}
 
-	err = k.fetchLogs(ctx, spec, step, output)
+	_, waitSpan := tracer.Start(ctx, "WaitContainerStart")
+	err = k.waitContainerStart(spec, step, watcher, log)
+	endSpan(waitSpan, err)
+
+	events.Unregister(containerId)
+
 	if err != nil {
 		return
 	}
 
+	stepStartLatency.Observe(time.Since(launched).Seconds())
+
 	type containerResult struct {
-		code int
-		err  error
+		termination podwatcher.Termination
+		err         error
 	}
 
-	chErrStop := make(chan containerResult)
+	// the container termination is awaited in the background, because
+	// the log streaming needs to know when the container terminates.
+	terminated := make(chan struct{})
+	chErrStop := make(chan containerResult, 1)
 	go func() {
-		code, err := watcher.WaitContainerTerminated(containerId)
-		chErrStop <- containerResult{code: code, err: err}
+		termination, err := watcher.WaitContainerTerminated(containerId)
+		close(terminated)
+		chErrStop <- containerResult{termination: termination, err: err}
 	}()
 
+	// the step timeout applies from the moment the step's container is started.
+	logCtx := ctx
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		logCtx, cancel = context.WithTimeout(ctx, step.Timeout)
+		defer cancel()
+	}
+
+	_, logSpan := tracer.Start(ctx, "StreamLogs")
+	err = k.fetchLogs(logCtx, spec, step, output, terminated)
+	endSpan(logSpan, err)
+	if err != nil && ctx.Err() != nil {
+		// the step is canceled, its container is stopped in the background
+		// so that the other steps of the pipeline are not held up.
+		log.Debug("Engine: Step canceled")
+		stopping = true
+		go k.stopContainer(spec, step, terminated, log)
+		return nil, ctx.Err()
+	}
+	if err != nil && logCtx.Err() == context.DeadlineExceeded {
+		log.WithField("timeout", step.Timeout).Debug("Engine: Step timed out")
+		_, _ = io.WriteString(output, fmt.Sprintf("Step %q timed out after %s\n", step.Name, step.Timeout))
+		if isDebugged(spec, step) {
+			// the step's container is still running, so the debug container can see its processes.
+			k.debugStep(ctx, spec, step, watcher, output, true, log)
+		}
+		stopping = true
+		go k.stopContainer(spec, step, terminated, log)
+		return &runtime.State{ExitCode: exitCodeTimeout, Exited: true}, nil
+	}
+	if err != nil {
+		return
+	}
+
+	_, terminationSpan := tracer.Start(ctx, "WaitContainerTerminated")
+	defer terminationSpan.End()
+
 	select {
 	case result := <-chErrStop:
 		err = result.err
@@ -248,25 +466,154 @@ func (k *Kubernetes) Run(ctx context.Context, specv runtime.Spec, stepv runtime.
 			return
 		}
 
+		if msg := describeTermination(step, result.termination); msg != "" {
+			log.WithField("exitCode", result.termination.ExitCode).
+				WithField("reason", result.termination.Reason).
+				WithField("podReason", result.termination.PodReason).
+				Debug("Engine: Abnormal container termination")
+			_, _ = io.WriteString(output, msg)
+		}
+
 		state = &runtime.State{
-			ExitCode:  result.code,
+			ExitCode:  result.termination.ExitCode,
 			Exited:    true,
-			OOMKilled: false,
+			OOMKilled: result.termination.OOMKilled(),
 		}
 	case <-spec.stop:
 		return nil, errPodStopped
 	}
 
+	if state.ExitCode != 0 && isDebugged(spec, step) {
+		k.debugStep(ctx, spec, step, watcher, output, false, log)
+	}
+
+	return
+}
+
+// isDebugged returns true if the pod is kept alive for debugging when the step fails.
+// The detached steps are not debugged, because the pipeline doesn't wait for them.
+func isDebugged(spec *Spec, step *Step) bool {
+	return spec.Debug != nil && !step.Detach
+}
+
+// waitContainerStart waits until the pod is scheduled to a node and the step's container is running.
+// The container start timeout applies only after the pod is scheduled.
+func (k *Kubernetes) waitContainerStart(spec *Spec, step *Step, watcher *podwatcher.PodWatcher, log logger.Logger) (err error) {
+	chErrScheduled := make(chan error, 1)
+	go func() {
+		chErrScheduled <- watcher.WaitPodScheduled(k.podScheduleTimeout)
+	}()
+
+	select {
+	case err = <-chErrScheduled:
+	case <-spec.stop:
+		return errPodStopped
+	}
+	if err != nil {
+		log.WithError(err).Error("Engine: Pod scheduling failed")
+		return
+	}
+
+	chErrStart := make(chan error)
+	go func() {
+		chErrStart <- watcher.WaitContainerStart(step.ID)
+	}()
+
+	select {
+	case err = <-chErrStart:
+	case <-time.After(k.containerStartTimeout):
+		err = podwatcher.StartTimeoutContainerError{Container: step.ID, Image: step.Image}
+		log.WithError(err).Error("Engine: Container start timeout")
+	case <-spec.stop:
+		return errPodStopped
+	}
+
 	return
 }
 
-func (k *Kubernetes) fetchLogs(ctx context.Context, spec *Spec, step *Step, output io.Writer) error {
-	// HACK: this timeout delays fetching the logs to ensure there is enough time to stream the logs.
-	// it does not delay the build speed.
-	time.Sleep(k.containerTimeToWaitForLogs)
+// deleteStepSecret deletes the step's secret, which is not needed anymore once the step is finished.
+func (k *Kubernetes) deleteStepSecret(spec *Spec, step *Step, log logger.Logger) {
+	if !spec.SecretPerStep || len(step.Secrets) == 0 {
+		return
+	}
+
+	err := k.client.CoreV1().Secrets(spec.PodSpec.Namespace).Delete(context.Background(), step.ID, metav1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		log.WithError(err).Warn("failed to delete step secret")
+	} else {
+		log.Trace("deleted step secret")
+	}
+}
+
+// fetchLogs streams logs of a step container to the output. If the log stream breaks before
+// the container terminates, the stream is reopened and continues from the last received line.
+// The function finishes after the container is terminated and the remaining logs are streamed.
+func (k *Kubernetes) fetchLogs(ctx context.Context, spec *Spec, step *Step, output io.Writer, terminated <-chan struct{}) error {
+	log := logger.FromContext(ctx).
+		WithField("pod", spec.PodSpec.Name).
+		WithField("namespace", spec.PodSpec.Namespace).
+		WithField("container", step.ID).
+		WithField("step", step.Name)
+
+	key := streamKey{pod: spec.PodSpec.Name, container: step.ID}
+	done := make(chan struct{})
+	k.streams.Store(key, done)
+	defer func() {
+		k.streams.Delete(key)
+		close(done)
+	}()
+
+	w := &timestampWriter{w: output}
+
+	for {
+		// if the container is already terminated, this is the last attempt to stream the logs.
+		var isFinal bool
+		select {
+		case <-terminated:
+			isFinal = true
+		default:
+		}
+
+		err := k.streamLogs(ctx, spec, step, w)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if isFinal {
+			if err != nil {
+				log.WithError(err).Warn("failed to stream logs of terminated container")
+			}
+			return w.Flush()
+		}
+
+		if err != nil {
+			log.WithError(err).Debug("log stream failed, reconnecting")
+		} else {
+			log.Trace("log stream closed, reconnecting")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-spec.stop:
+			return errPodStopped
+		case <-terminated:
+		case <-time.After(logStreamRetryDelay):
+		}
+	}
+}
+
+// streamLogs opens a single log stream of a step container and copies it to the writer.
+func (k *Kubernetes) streamLogs(ctx context.Context, spec *Spec, step *Step, w *timestampWriter) error {
 	opts := &v1.PodLogOptions{
-		Follow:    true,
-		Container: step.ID,
+		Follow:     true,
+		Container:  step.ID,
+		Timestamps: true,
+	}
+
+	if since := w.Since(); since != nil {
+		t := metav1.NewTime(*since)
+		opts.SinceTime = &t
 	}
 
 	req := k.client.CoreV1().RESTClient().Get().
@@ -278,18 +625,55 @@ func (k *Kubernetes) fetchLogs(ctx context.Context, spec *Spec, step *Step, outp
 
 	readCloser, err := req.Stream(ctx)
 	if err != nil {
-		logger.FromContext(ctx).
-			WithError(err).
-			WithField("pod", spec.PodSpec.Name).
-			WithField("namespace", spec.PodSpec.Namespace).
-			WithField("container", step.ID).
-			WithField("step", step.Name).
-			Error("failed to stream logs")
 		return err
 	}
 	defer readCloser.Close()
 
-	return cancellableCopy(ctx, output, readCloser)
+	w.Reset()
+
+	return cancellableCopy(ctx, w, readCloser)
+}
+
+// waitLogStreams waits until all in-flight log streams of the pod are finished
+// or until the log stream timeout expires. It returns number of unfinished streams.
+func (k *Kubernetes) waitLogStreams(podName string) (pending int) {
+	var streams []chan struct{}
+	k.streams.Range(func(key, value interface{}) bool {
+		if key.(streamKey).pod == podName {
+			streams = append(streams, value.(chan struct{}))
+		}
+		return true
+	})
+
+	if len(streams) == 0 {
+		return 0
+	}
+
+	timeout := time.NewTimer(k.logStreamTimeout)
+	defer timeout.Stop()
+
+	for i, done := range streams {
+		select {
+		case <-done:
+		case <-timeout.C:
+			return len(streams) - i
+		}
+	}
+
+	return 0
+}
+
+func (k *Kubernetes) startEventWatcher(spec *Spec) *podwatcher.EventWatcher {
+	podName := spec.PodSpec.Name
+	podNamespace := spec.PodSpec.Namespace
+
+	ew, loaded := k.events.LoadOrStore(podName, podwatcher.NewEventWatcher(podName, podNamespace, k.client))
+	events := ew.(*podwatcher.EventWatcher)
+	if !loaded {
+		events.Start(context.Background())
+	}
+
+	return events
 }
 
 func (k *Kubernetes) startContainer(ctx context.Context, spec *Spec, step *Step) <-chan error {
@@ -298,10 +682,11 @@ func (k *Kubernetes) startContainer(ctx context.Context, spec *Spec, step *Step)
 	containerName := step.ID
 	containerImage := step.Image
 
-	_l, loaded := k.launchers.LoadOrStore(podName, launcher.New(podName, podNamespace, k.client, &spec.podUpdateMutex))
+	_l, loaded := k.launchers.LoadOrStore(podName, launcher.New(podName, podNamespace, k.client))
 	l := _l.(*launcher.Launcher)
 	if !loaded {
-		l.Start(ctx)
+		// the launcher is shared by all steps, and it's stopped by Destroy.
+		l.Start(context.Background())
 	}
 
 	statusEnvs := make(map[string]string)
@@ -0,0 +1,115 @@
+// Copyright 2019 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestWaitLogStreams(t *testing.T) {
+	k := &Kubernetes{
+		streams:          &sync.Map{},
+		logStreamTimeout: 50 * time.Millisecond,
+	}
+
+	if got := k.waitLogStreams("pod"); got != 0 {
+		t.Errorf("expected no pending streams, got %d", got)
+	}
+
+	doneA := make(chan struct{})
+	doneB := make(chan struct{})
+	k.streams.Store(streamKey{pod: "pod", container: "a"}, doneA)
+	k.streams.Store(streamKey{pod: "pod", container: "b"}, doneB)
+	k.streams.Store(streamKey{pod: "other", container: "c"}, make(chan struct{}))
+
+	close(doneA)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(doneB)
+	}()
+
+	if got := k.waitLogStreams("pod"); got != 0 {
+		t.Errorf("expected no pending streams, got %d", got)
+	}
+
+	if got := k.waitLogStreams("other"); got != 1 {
+		t.Errorf("expected one pending stream, got %d", got)
+	}
+}
+
+func TestSetup_SecretOwner(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	k := New(client, nil, nil, time.Minute, time.Minute, time.Minute, false)
+
+	spec := &Spec{
+		PullSecret: &Secret{Name: "drone-pull", Data: "{}"},
+	}
+	spec.PodSpec.Name = "drone-pod"
+	spec.PodSpec.Namespace = "default"
+
+	if err := k.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"drone-pod", "drone-pull"} {
+		secret, err := client.CoreV1().Secrets("default").Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(secret.OwnerReferences) != 1 {
+			t.Errorf("expected secret %s to have one owner, got %v", name, secret.OwnerReferences)
+			continue
+		}
+
+		if owner := secret.OwnerReferences[0]; owner.Kind != "Pod" || owner.Name != "drone-pod" {
+			t.Errorf("expected secret %s to be owned by the pod, got %v", name, owner)
+		}
+	}
+}
+
+// This test verifies that the pipeline runs if the runner isn't allowed to set the
+// owner of the secrets, and that the secrets are deleted when the pipeline ends.
+func TestSetup_SecretOwnerForbidden(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("patch", "secrets", func(k8stesting.Action) (bool, k8sruntime.Object, error) {
+		return true, nil, kerrors.NewForbidden(v1.Resource("secrets"), "drone-pod", errors.New("patch is not allowed"))
+	})
+
+	k := New(client, nil, nil, time.Minute, time.Minute, time.Minute, false)
+
+	spec := &Spec{
+		PullSecret: &Secret{Name: "drone-pull", Data: "{}"},
+	}
+	spec.PodSpec.Name = "drone-pod"
+	spec.PodSpec.Namespace = "default"
+
+	if err := k.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := k.Destroy(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+
+	secrets, err := client.CoreV1().Secrets("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets.Items) != 0 {
+		t.Errorf("expected the secrets to be deleted, got %v", secrets.Items)
+	}
+}
@@ -6,27 +6,32 @@ package launcher
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"sync"
+	"sort"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/util/retry"
 )
 
 const aggregateTimer = 400 * time.Millisecond
 
+// ErrStopped is returned for launch requests received after the Launcher has been stopped.
+var ErrStopped = errors.New("launcher has been stopped")
+
 // Launcher is used to launch several containers at once. It uses a timer to
 // collect potentially several container launch events.
-// Than the containers are updates with a single call to Kubernetes API.
+// Than the containers are updated with a single patch request to Kubernetes API.
 type Launcher struct {
 	stop, stopped chan struct{}
 
-	kubeClient  kubernetes.Interface
-	podUpdateMx *sync.Mutex
+	kubeClient kubernetes.Interface
 
 	podNamespace string
 	podName      string
@@ -34,6 +39,9 @@ type Launcher struct {
 	timer     *time.Timer
 	requests  map[string]*request
 	requestCh chan request
+
+	// containers holds the names of the pod's containers.
+	containers map[string]struct{}
 }
 
 type request struct {
@@ -45,12 +53,11 @@ type request struct {
 }
 
 // New creates a new Launcher.
-func New(podName, podNamespace string, clientset kubernetes.Interface, podUpdateMx *sync.Mutex) *Launcher {
+func New(podName, podNamespace string, clientset kubernetes.Interface) *Launcher {
 	l := &Launcher{
 		stop:         make(chan struct{}),
 		stopped:      make(chan struct{}),
 		kubeClient:   clientset,
-		podUpdateMx:  podUpdateMx,
 		podNamespace: podNamespace,
 		podName:      podName,
 		timer:        nil,
@@ -79,7 +86,13 @@ func (l *Launcher) Start(ctx context.Context) {
 	l.timer = t
 
 	go func() {
-		defer close(l.stopped)
+		defer func() {
+			for _, req := range l.requests {
+				req.chErr <- ErrStopped
+			}
+			l.requests = nil
+			close(l.stopped)
+		}()
 
 		for {
 			select {
@@ -114,8 +127,8 @@ func (l *Launcher) Start(ctx context.Context) {
 
 // Launch schedules launch of a pod's container.
 func (l *Launcher) Launch(containerID, containerImage string, statusEnvs map[string]string) <-chan error {
-	chErr := make(chan error)
-	l.requestCh <- request{
+	chErr := make(chan error, 1)
+	req := request{
 		containerID:    containerID,
 		containerImage: containerImage,
 		chErr:          chErr,
@@ -123,55 +136,41 @@ func (l *Launcher) Launch(containerID, containerImage string, statusEnvs map[str
 		found:          false,
 	}
 
+	select {
+	case l.requestCh <- req:
+	case <-l.stopped:
+		chErr <- ErrStopped
+	}
+
 	return chErr
 }
 
 func (l *Launcher) startContainers(ctx context.Context, requests map[string]*request) {
-	var backoff = wait.Backoff{
-		Steps:    15,
-		Duration: 500 * time.Millisecond,
-		Factor:   1.0,
-		Jitter:   0.5,
-	}
+	batchSize.Observe(float64(len(requests)))
 
 	t := time.Now()
 
-	err := retry.RetryOnConflict(backoff, func() error {
-		// We protect this read/modify/write with a mutex to reduce the
-		// chance of a self-inflicted concurrent modification error
-		// when a DAG in a pipeline is fanning out and we have a lot of
-		// steps to Start at once.
-		l.podUpdateMx.Lock()
-		defer l.podUpdateMx.Unlock()
-
-		pod, err := l.kubeClient.CoreV1().Pods(l.podNamespace).Get(ctx, l.podName, metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
-
-		for i, container := range pod.Spec.Containers {
-			req, ok := requests[container.Name]
-			if !ok {
-				continue
-			}
-
-			req.found = true
-
-			pod.Spec.Containers[i].Image = req.containerImage
-
-			if pod.ObjectMeta.Annotations == nil {
-				pod.ObjectMeta.Annotations = map[string]string{}
-			}
-			for envName, envValue := range req.statusEnvs {
-				pod.ObjectMeta.Annotations[envName] = envValue
-			}
+	err := l.loadContainers(ctx)
+	if err == nil {
+		var patch []byte
+		patch, err = l.patch(requests)
+		if err == nil && patch != nil {
+			// The patch doesn't carry the pod's resource version and replaces only the images
+			// of the listed containers, so it's applied regardless of concurrent modifications
+			// of the pod. Conflicts are still possible, although rare, if the api server fails
+			// to apply the patch to the latest version of the pod.
+			err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+				_, err := l.kubeClient.CoreV1().Pods(l.podNamespace).Patch(ctx, l.podName, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+				if kerrors.IsConflict(err) {
+					conflicts.Inc()
+				}
+				return err
+			})
 		}
-
-		_, err = l.kubeClient.CoreV1().Pods(l.podNamespace).Update(ctx, pod, metav1.UpdateOptions{})
-
-		return err
-	})
+	}
 	if err != nil {
+		failures.Inc()
+
 		logrus.
 			WithError(err).
 			Errorf("Launch of %d containers failed. Duration=%.2fs", len(requests), time.Since(t).Seconds())
@@ -205,3 +204,75 @@ func (l *Launcher) startContainers(ctx context.Context, requests map[string]*req
 		req.chErr <- nil
 	}
 }
+
+// loadContainers reads the names of the pod's containers. The list of containers
+// of a pod can't be changed, so the pod is read only once.
+func (l *Launcher) loadContainers(ctx context.Context) error {
+	if l.containers != nil {
+		return nil
+	}
+
+	pod, err := l.kubeClient.CoreV1().Pods(l.podNamespace).Get(ctx, l.podName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	l.containers = make(map[string]struct{}, len(pod.Spec.Containers))
+	for _, container := range pod.Spec.Containers {
+		l.containers[container.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// patch returns a strategic merge patch that changes the images of the pod's containers,
+// which are matched by name, and sets the status annotations. It returns nil if none of
+// the requested containers belong to the pod.
+func (l *Launcher) patch(requests map[string]*request) ([]byte, error) {
+	type containerPatch struct {
+		Name  string `json:"name"`
+		Image string `json:"image"`
+	}
+
+	var containers []containerPatch
+	annotations := map[string]string{}
+
+	for _, req := range requests {
+		if _, ok := l.containers[req.containerID]; !ok {
+			continue
+		}
+
+		req.found = true
+
+		containers = append(containers, containerPatch{
+			Name:  req.containerID,
+			Image: req.containerImage,
+		})
+
+		for envName, envValue := range req.statusEnvs {
+			annotations[envName] = envValue
+		}
+	}
+
+	if len(containers) == 0 {
+		return nil, nil
+	}
+
+	// sort the containers to make the patch deterministic
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Name < containers[j].Name
+	})
+
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"containers": containers,
+		},
+	}
+	if len(annotations) > 0 {
+		patch["metadata"] = map[string]interface{}{
+			"annotations": annotations,
+		}
+	}
+
+	return json.Marshal(patch)
+}
@@ -0,0 +1,75 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package launcher
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestLauncher(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{Name: "step1", Image: "placeholder"},
+				{Name: "step2", Image: "placeholder"},
+				{Name: "step3", Image: "placeholder"},
+			},
+		},
+	})
+
+	l := New("pod", "default", client)
+	l.Start(context.Background())
+	defer l.Stop()
+
+	err1 := l.Launch("step1", "golang", map[string]string{"DRONE_BUILD_STATUS": "success"})
+	err2 := l.Launch("step2", "node", nil)
+	errUnknown := l.Launch("unknown", "alpine", nil)
+
+	if err := <-err1; err != nil {
+		t.Errorf("step1: unexpected error: %s", err)
+	}
+	if err := <-err2; err != nil {
+		t.Errorf("step2: unexpected error: %s", err)
+	}
+	if err := <-errUnknown; err == nil {
+		t.Errorf("expected error for unknown container")
+	}
+
+	pod, err := client.CoreV1().Pods("default").Get(context.Background(), "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"golang", "node", "placeholder"}
+	for i, container := range pod.Spec.Containers {
+		if container.Image != want[i] {
+			t.Errorf("container %s: want image %s, got %s", container.Name, want[i], container.Image)
+		}
+	}
+
+	if got := pod.Annotations["DRONE_BUILD_STATUS"]; got != "success" {
+		t.Errorf("want status annotation success, got %q", got)
+	}
+
+	var patches, updates int
+	for _, action := range client.Actions() {
+		switch action.(type) {
+		case k8stesting.PatchAction:
+			patches++
+		case k8stesting.UpdateAction:
+			updates++
+		}
+	}
+	if patches != 1 || updates != 0 {
+		t.Errorf("want a single patch request, got %d patches and %d updates", patches, updates)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package launcher
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// batchSize is the number of containers launched with a single request.
+	batchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "drone_launcher_batch_size",
+		Help:    "Number of containers launched with a single pod patch request.",
+		Buckets: []float64{1, 2, 4, 8, 16, 32, 64},
+	})
+
+	// conflicts is the number of pod patch requests rejected with the 409 Conflict status.
+	conflicts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "drone_launcher_conflicts_total",
+		Help: "Number of pod patch requests that failed because of a conflict and were retried.",
+	})
+
+	// failures is the number of container batches that failed to launch.
+	failures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "drone_launcher_failures_total",
+		Help: "Number of container batches that failed to launch.",
+	})
+)
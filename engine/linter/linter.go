@@ -62,6 +62,9 @@ func (l *Linter) Lint(pm manifest.Resource, repo *drone.Repo) error {
 	if err := checkVolumes(pipeline, repo.Trusted); err != nil {
 		return err
 	}
+	if err := checkScheduling(pipeline, repo.Trusted); err != nil {
+		return err
+	}
 	if err := checkNamespace(pipeline.Metadata.Namespace, repo.Slug, l.patterns); err != nil {
 		return err
 	}
@@ -204,6 +207,19 @@ func checkEmptyDirVolume(volume *resource.VolumeEmptyDir, trusted bool) error {
 	return nil
 }
 
+func checkScheduling(pipeline *resource.Pipeline, trusted bool) error {
+	if trusted == false && pipeline.Affinity != nil {
+		return errors.New("linter: untrusted repositories cannot set affinity")
+	}
+	if trusted == false && len(pipeline.TopologySpreadConstraints) != 0 {
+		return errors.New("linter: untrusted repositories cannot set topology spread constraints")
+	}
+	if trusted == false && pipeline.Cluster != "" {
+		return errors.New("linter: untrusted repositories cannot set the cluster")
+	}
+	return nil
+}
+
 func checkNamespace(namespace, name string, mapping map[string][]string) error {
 	if len(mapping) == 0 {
 		return nil
@@ -191,6 +191,42 @@ func TestLint(t *testing.T) {
 			repo:     "spaceghost/hello-world",
 			message:  "linter: pipeline restricted from using configured namespace",
 		},
+		// user should not be able to set the affinity, the
+		// topology spread constraints or the cluster unless
+		// the repository is trusted.
+		{
+			path:    "testdata/affinity.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: untrusted repositories cannot set affinity",
+		},
+		{
+			path:    "testdata/affinity.yml",
+			trusted: true,
+			invalid: false,
+		},
+		{
+			path:    "testdata/topology_spread.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: untrusted repositories cannot set topology spread constraints",
+		},
+		{
+			path:    "testdata/topology_spread.yml",
+			trusted: true,
+			invalid: false,
+		},
+		{
+			path:    "testdata/cluster.yml",
+			trusted: false,
+			invalid: true,
+			message: "linter: untrusted repositories cannot set the cluster",
+		},
+		{
+			path:    "testdata/cluster.yml",
+			trusted: true,
+			invalid: false,
+		},
 
 		//
 		// The below checks were moved to the parser, however, we
@@ -0,0 +1,113 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// timestampWriter removes timestamps that kubernetes prepends to each log line
+// when the logs are requested with PodLogOptions.Timestamps. The timestamps are used
+// to skip the lines that were already written before the log stream got reconnected.
+// The writer expects that each call to Write provides a single line, as livelog.Copy does.
+type timestampWriter struct {
+	w io.Writer
+
+	// last is the timestamp of the last written line.
+	last time.Time
+
+	// lastCount is the number of written lines with the timestamp equal to last.
+	lastCount int
+
+	// repeated is the number of lines with the timestamp equal to last
+	// received since the log stream was (re)connected.
+	repeated int
+
+	// partial holds an incomplete line, i.e. a line without the trailing new line character.
+	partial []byte
+}
+
+// Since returns the time from which the logs should be requested after a reconnect.
+// The function returns nil if nothing has been written yet.
+func (w *timestampWriter) Since() *time.Time {
+	if w.last.IsZero() {
+		return nil
+	}
+	t := w.last
+	return &t
+}
+
+// Reset should be called every time the log stream is (re)connected.
+func (w *timestampWriter) Reset() {
+	w.repeated = 0
+	w.partial = nil // incomplete line will be received again
+}
+
+// Flush writes the incomplete line, if any. It should be called after the last log stream finishes.
+func (w *timestampWriter) Flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+
+	_, line := splitTimestamp(w.partial)
+	w.partial = nil
+
+	_, err := w.w.Write(line)
+	return err
+}
+
+func (w *timestampWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if p[len(p)-1] != '\n' {
+		// the stream has been broken in the middle of a line or the line is the last one in the log
+		w.partial = append(w.partial[:0], p...)
+		return len(p), nil
+	}
+
+	ts, line := splitTimestamp(p)
+	if !ts.IsZero() {
+		switch {
+		case ts.Before(w.last):
+			return len(p), nil // already written
+		case ts.Equal(w.last):
+			w.repeated++
+			if w.repeated <= w.lastCount {
+				return len(p), nil // already written
+			}
+			w.lastCount++
+		default:
+			w.last = ts
+			w.lastCount = 1
+			w.repeated = 1
+		}
+	}
+
+	if _, err := w.w.Write(line); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// splitTimestamp splits a log line to the timestamp and the rest of the line.
+// If the line doesn't start with a timestamp, zero time and the unchanged line is returned.
+func splitTimestamp(p []byte) (time.Time, []byte) {
+	idx := bytes.IndexByte(p, ' ')
+	if idx <= 0 {
+		return time.Time{}, p
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, string(p[:idx]))
+	if err != nil {
+		return time.Time{}, p
+	}
+
+	return ts, p[idx+1:]
+}
@@ -0,0 +1,57 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTimestampWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := &timestampWriter{w: buf}
+
+	write := func(lines ...string) {
+		for _, line := range lines {
+			if _, err := w.Write([]byte(line)); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	// the first stream, it breaks in the middle of the line "d"
+	w.Reset()
+	write(
+		"2022-03-01T10:00:00.100000000Z a\n",
+		"2022-03-01T10:00:00.200000000Z b\n",
+		"2022-03-01T10:00:00.200000000Z c\n",
+		"2022-03-01T10:00:01.000000000Z d-part",
+	)
+
+	if since := w.Since(); since == nil || since.Nanosecond() != 200000000 {
+		t.Errorf("unexpected since time: %v", since)
+	}
+
+	// the second stream, with seconds precision it repeats the already written lines
+	w.Reset()
+	write(
+		"2022-03-01T10:00:00.100000000Z a\n",
+		"2022-03-01T10:00:00.200000000Z b\n",
+		"2022-03-01T10:00:00.200000000Z c\n",
+		"2022-03-01T10:00:00.200000000Z c2\n",
+		"2022-03-01T10:00:01.000000000Z d-part-complete\n",
+		"no timestamp\n",
+		"2022-03-01T10:00:02.000000000Z e",
+	)
+
+	if err := w.Flush(); err != nil {
+		t.Error(err)
+	}
+
+	want := "a\nb\nc\nc2\nd-part-complete\nno timestamp\ne"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+// Package mesh provides support for the service meshes, such as
+// Istio and Linkerd, that inject proxy sidecar containers into pods.
+package mesh
+
+import "fmt"
+
+// Injection modes of the proxy sidecars.
+const (
+	// InjectionDefault leaves the decision to the service mesh,
+	// which usually injects the proxy if it's enabled for the namespace.
+	InjectionDefault = ""
+
+	// InjectionEnabled explicitly allows the injection of the proxy.
+	InjectionEnabled = "enabled"
+
+	// InjectionDisabled prevents the injection of the proxy.
+	InjectionDisabled = "disabled"
+)
+
+// Proxy describes a service mesh proxy that runs as a sidecar container.
+type Proxy struct {
+	// Container is the name of the injected container.
+	Container string
+
+	// Command shuts the proxy down, if executed in the proxy's container.
+	// It's empty if the proxy's image has no tool to do so.
+	Command []string
+
+	// Port and ShutdownPath locate the proxy's endpoint that shuts the proxy down.
+	// The proxy accepts the shutdown requests only from localhost, so the endpoint
+	// must be requested from a container of the pod.
+	Port         int
+	ShutdownPath string
+}
+
+// ShutdownURL returns the URL of the proxy's shutdown endpoint, in the pod's network.
+func (p Proxy) ShutdownURL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d/%s", p.Port, p.ShutdownPath)
+}
+
+// proxies holds the proxies of the supported service meshes.
+var proxies = []Proxy{
+	{Container: "istio-proxy", Command: []string{"pilot-agent", "request", "POST", "quitquitquit"}, Port: 15020, ShutdownPath: "quitquitquit"},
+	{Container: "linkerd-proxy", Port: 4191, ShutdownPath: "shutdown"},
+}
+
+// Find returns the proxy that runs in the named container.
+func Find(container string) (Proxy, bool) {
+	for _, p := range proxies {
+		if p.Container == container {
+			return p, true
+		}
+	}
+	return Proxy{}, false
+}
+
+// IsProxy returns true if the named container is an injected proxy.
+func IsProxy(container string) bool {
+	_, ok := Find(container)
+	return ok
+}
+
+// Annotations returns the pod annotations that enable or disable
+// the injection of the proxies, depending on the injection mode.
+func Annotations(injection string) map[string]string {
+	switch injection {
+	case InjectionEnabled:
+		return map[string]string{
+			"sidecar.istio.io/inject": "true",
+			"linkerd.io/inject":       "enabled",
+		}
+	case InjectionDisabled:
+		return map[string]string{
+			"sidecar.istio.io/inject": "false",
+			"linkerd.io/inject":       "disabled",
+		}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package mesh
+
+import "testing"
+
+func TestFind(t *testing.T) {
+	if p, ok := Find("istio-proxy"); !ok || p.Port != 15020 || p.ShutdownPath != "quitquitquit" {
+		t.Errorf("unexpected istio proxy: %+v", p)
+	}
+	if p, _ := Find("linkerd-proxy"); p.ShutdownURL() != "http://127.0.0.1:4191/shutdown" {
+		t.Errorf("unexpected linkerd shutdown url: %s", p.ShutdownURL())
+	}
+	if !IsProxy("linkerd-proxy") {
+		t.Errorf("expected linkerd-proxy to be a proxy")
+	}
+	if IsProxy("drone-step") {
+		t.Errorf("expected drone-step not to be a proxy")
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	if got := Annotations(InjectionDisabled)["sidecar.istio.io/inject"]; got != "false" {
+		t.Errorf("want istio injection disabled, got %q", got)
+	}
+	if got := Annotations(InjectionEnabled)["linkerd.io/inject"]; got != "enabled" {
+		t.Errorf("want linkerd injection enabled, got %q", got)
+	}
+	if got := Annotations(InjectionDefault); got != nil {
+		t.Errorf("want no annotations, got %v", got)
+	}
+}
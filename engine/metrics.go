@@ -0,0 +1,58 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+
+	"github.com/drone/runner-go/pipeline/runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	stagesRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "drone_stages_running",
+		Help: "Number of pipeline stages currently running.",
+	})
+
+	stagesFinished = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "drone_stages_finished_total",
+		Help: "Number of finished pipeline stages.",
+	})
+
+	stepsRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "drone_steps_running",
+		Help: "Number of pipeline steps currently running.",
+	})
+
+	stepsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "drone_steps_finished_total",
+		Help: "Number of finished pipeline steps, partitioned by the result (success, failure, error or canceled).",
+	}, []string{"result"})
+
+	// stepStartLatency is the time from the launch of a step's container until the container is running.
+	// It includes the pod scheduling and the image pull.
+	stepStartLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "drone_step_start_latency_seconds",
+		Help:    "Time from the launch of a step's container until the container is running.",
+		Buckets: []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600},
+	})
+)
+
+// stepResult returns the result label of a finished step.
+func stepResult(state *runtime.State, err error) string {
+	switch {
+	case err == context.Canceled || err == errPodStopped:
+		return "canceled"
+	case err != nil || state == nil:
+		return "error"
+	case state.ExitCode != 0:
+		return "failure"
+	default:
+		return "success"
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/pipeline/runtime"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// nativeSidecarVersion is the first Kubernetes version with the native sidecar containers enabled by default.
+// They were introduced in 1.28, but behind a feature gate, and without it the API server silently drops the
+// container's restart policy, so a service would run as a regular init container and block the pod forever.
+var nativeSidecarVersion = version.MustParseGeneric("v1.29.0")
+
+// isNativeSidecar returns true if the step is a service run as a native sidecar container. The services
+// that are never run keep their placeholder containers, because an init container can't be skipped.
+func isNativeSidecar(spec *Spec, step *Step) bool {
+	return spec.nativeSidecars && step.Service && step.RunPolicy != runtime.RunNever
+}
+
+// hasServices returns true if the pipeline has services that could be run as native sidecar containers.
+func hasServices(spec *Spec) bool {
+	for _, step := range spec.Steps {
+		if step.Service && step.RunPolicy != runtime.RunNever {
+			return true
+		}
+	}
+	return false
+}
+
+// supportsNativeSidecars returns true if the Kubernetes cluster runs the pipeline services as native sidecars.
+// The server version is read only once, unless the request fails, in which case the services run as before.
+func (k *Kubernetes) supportsNativeSidecars(log logger.Logger) bool {
+	if !k.nativeSidecars {
+		return false
+	}
+
+	k.sidecarSupportMx.Lock()
+	defer k.sidecarSupportMx.Unlock()
+
+	if k.sidecarSupport != nil {
+		return *k.sidecarSupport
+	}
+
+	info, err := k.client.Discovery().ServerVersion()
+	if err != nil {
+		log.WithError(err).Warn("failed to read kubernetes server version")
+		return false
+	}
+
+	v, err := version.ParseGeneric(info.GitVersion)
+	supported := err == nil && v.AtLeast(nativeSidecarVersion)
+	k.sidecarSupport = &supported
+
+	log.WithField("version", info.GitVersion).
+		WithField("native_sidecars", supported).
+		Debug("read kubernetes server version")
+
+	return supported
+}
+
+// createNativeSidecarPod creates the pod with the services as native sidecar containers.
+func (k *Kubernetes) createNativeSidecarPod(ctx context.Context, spec *Spec) (*v1.Pod, error) {
+	body, err := toNativeSidecarPod(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	pod := &v1.Pod{}
+	err = k.client.CoreV1().RESTClient().Post().
+		Namespace(spec.PodSpec.Namespace).
+		Resource("pods").
+		SetHeader("Content-Type", "application/json").
+		Body(body).
+		Do(ctx).
+		Into(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	return pod, nil
+}
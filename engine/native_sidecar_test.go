@@ -0,0 +1,40 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSupportsNativeSidecars(t *testing.T) {
+	tests := []struct {
+		version string
+		enabled bool
+		want    bool
+	}{
+		{version: "v1.29.0", enabled: true, want: true},
+		{version: "v1.30.2-gke.1587003", enabled: true, want: true},
+		{version: "v1.28.9", enabled: true, want: false},
+		{version: "v1.21.8", enabled: true, want: false},
+		{version: "v1.29.0", enabled: false, want: false},
+	}
+
+	for _, test := range tests {
+		client := fake.NewSimpleClientset()
+		client.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: test.version}
+
+		k := New(client, nil, nil, time.Minute, time.Minute, time.Minute, test.enabled)
+		if got := k.supportsNativeSidecars(logger.Discard()); got != test.want {
+			t.Errorf("version %s, enabled %t: want %t, got %t", test.version, test.enabled, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/pipeline/runtime"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// workspaceVolumeName is the name of the volume the compiler creates for the pipeline's workspace.
+const workspaceVolumeName = "_workspace"
+
+// labelHostname is the node label that the step pods are pinned to.
+const labelHostname = "kubernetes.io/hostname"
+
+// WorkspaceClaim configures the persistent volume claim that holds the workspace of a pipeline
+// run by the PodPerStep engine.
+type WorkspaceClaim struct {
+	// StorageClass is the storage class of the claim. If empty, the cluster's default is used.
+	StorageClass string
+
+	// Size is the requested size of the workspace.
+	Size resource.Quantity
+
+	// AccessMode is the access mode of the claim. With ReadWriteMany the step pods can run on
+	// any node. With ReadWriteOnce all step pods of a pipeline are run on the same node.
+	AccessMode v1.PersistentVolumeAccessMode
+}
+
+// PodPerStep implements a Kubernetes pipeline engine that runs each step in its own pod,
+// so that a pipeline isn't limited by the number of containers and the size of a single pod,
+// and its steps can run on different nodes. The steps share the workspace through a persistent
+// volume claim. The step pods are run by the Kubernetes engine, the same way as a pipeline pod.
+type PodPerStep struct {
+	k         *Kubernetes
+	workspace WorkspaceClaim
+	stages    *sync.Map
+}
+
+// stepPods holds the state of a pipeline run by the PodPerStep engine.
+type stepPods struct {
+	mx   sync.Mutex
+	pods map[string]*Spec
+
+	// hosts holds the addresses of the pipeline's services, by service name.
+	hosts map[string]*serviceHost
+
+	// node is the node that runs the step pods, if the workspace claim can be mounted only on a single node.
+	node *stageNode
+}
+
+// stageNode is the node of the first step pod of a pipeline. The pod affinity to the pipeline's pods
+// applies only to the pods already scheduled, so the pods of the parallel steps could be scheduled to
+// different nodes, if the node isn't known when they are created.
+type stageNode struct {
+	mx     sync.Mutex
+	placed bool
+
+	once  sync.Once
+	ready chan struct{}
+	name  string
+}
+
+func newStageNode() *stageNode {
+	return &stageNode{ready: make(chan struct{})}
+}
+
+// place returns true for the first step pod, which selects the node.
+func (n *stageNode) place() bool {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+
+	first := !n.placed
+	n.placed = true
+	return first
+}
+
+func (n *stageNode) resolve(name string) {
+	n.once.Do(func() {
+		n.name = name
+		close(n.ready)
+	})
+}
+
+// serviceHost is the address of a service, which is known once its pod is assigned an IP address.
+type serviceHost struct {
+	startOnce sync.Once
+	started   chan struct{}
+
+	once  sync.Once
+	ready chan struct{}
+	ip    string
+}
+
+func newServiceHost() *serviceHost {
+	return &serviceHost{
+		started: make(chan struct{}),
+		ready:   make(chan struct{}),
+	}
+}
+
+// start marks the service as started, once its pod is created.
+func (h *serviceHost) start() {
+	h.startOnce.Do(func() {
+		close(h.started)
+	})
+}
+
+func (h *serviceHost) isStarted() bool {
+	select {
+	case <-h.started:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *serviceHost) resolve(ip string) {
+	h.start()
+	h.once.Do(func() {
+		h.ip = ip
+		close(h.ready)
+	})
+}
+
+// NewPodPerStep returns a new engine that runs each step of a pipeline in its own pod, using the Kubernetes engine.
+func NewPodPerStep(k *Kubernetes, workspace WorkspaceClaim) *PodPerStep {
+	return &PodPerStep{
+		k:         k,
+		workspace: workspace,
+		stages:    &sync.Map{},
+	}
+}
+
+// Setup the pipeline environment. It creates the workspace claim and the secrets,
+// the step pods are created when the steps are run.
+func (e *PodPerStep) Setup(ctx context.Context, specv runtime.Spec) (err error) {
+	spec := specv.(*Spec)
+
+	ctx, span := startStageSpan(ctx, spec, "Setup")
+	defer func() { endSpan(span, err) }()
+
+	log := logger.FromContext(ctx).
+		WithField("pod", spec.PodSpec.Name).
+		WithField("namespace", spec.PodSpec.Namespace)
+
+	// the pipeline is marked as active before any resource is created,
+	// so that the resources are never considered orphaned.
+	e.k.stages.Store(spec.PodSpec.Name, struct{}{})
+	stagesRunning.Inc()
+
+	// every step pod references only the secrets of its step.
+	spec.SecretPerStep = true
+
+	if err = e.k.createNamespace(ctx, spec, log); err != nil {
+		return err
+	}
+
+	claim, err := e.k.client.CoreV1().PersistentVolumeClaims(spec.PodSpec.Namespace).Create(ctx, toWorkspaceClaim(spec, e.workspace), metav1.CreateOptions{})
+	if err != nil {
+		log.WithError(err).Error("failed to create workspace claim")
+		return err
+	}
+	log.Trace("created workspace claim")
+
+	// the workspace claim owns the secrets, so they are deleted with it.
+	owner := toClaimOwnerReference(claim)
+
+	secrets := toSecrets(spec)
+	if err = e.k.createSecrets(ctx, spec.PodSpec.Namespace, secrets, log); err != nil {
+		e.deleteClaim(spec, log)
+		return err
+	}
+	e.k.setSecretsOwner(ctx, spec, secrets, owner, log)
+
+	hosts := make(map[string]*serviceHost)
+	for _, alias := range spec.PodSpec.HostAliases {
+		if alias.IP != "127.0.0.1" {
+			continue
+		}
+		for _, hostname := range alias.Hostnames {
+			hosts[hostname] = newServiceHost()
+		}
+	}
+
+	// the services that are never run would never get an address.
+	for _, step := range spec.Steps {
+		if host, ok := hosts[step.Name]; ok && (!step.Detach || step.RunPolicy == runtime.RunNever) {
+			host.resolve("")
+		}
+	}
+
+	e.stages.Store(spec.PodSpec.Name, &stepPods{
+		pods:  make(map[string]*Spec),
+		hosts: hosts,
+		node:  newStageNode(),
+	})
+
+	spec.stop = make(chan struct{})
+
+	return nil
+}
+
+// Destroy the pipeline environment.
+func (e *PodPerStep) Destroy(ctx context.Context, specv runtime.Spec) error {
+	spec := specv.(*Spec)
+
+	ctx, span := startStageSpan(ctx, spec, "Destroy")
+	defer span.End()
+
+	log := logger.FromContext(ctx).
+		WithField("pod", spec.PodSpec.Name).
+		WithField("namespace", spec.PodSpec.Namespace)
+
+	var pods []*Spec
+	if st, loaded := e.stages.LoadAndDelete(spec.PodSpec.Name); loaded {
+		st := st.(*stepPods)
+		st.mx.Lock()
+		for _, pod := range st.pods {
+			pods = append(pods, pod)
+		}
+		st.pods = nil // no more step pods are created
+		st.mx.Unlock()
+	}
+
+	// the step pods still running are services, or the steps that haven't finished yet.
+	for _, pod := range pods {
+		if n := e.k.waitLogStreams(pod.PodSpec.Name); n > 0 {
+			log.WithField("streams", n).Warn("timeout waiting for log streams to finish")
+		}
+	}
+
+	if spec.stop != nil {
+		close(spec.stop)
+	}
+
+	for _, pod := range pods {
+		e.k.releasePod(pod, log.WithField("pod", pod.PodSpec.Name))
+	}
+
+	e.k.deleteSecrets(spec.PodSpec.Namespace, spec.unownedSecrets, log)
+	e.deleteClaim(spec, log)
+	e.k.deleteNamespace(spec, log)
+	e.k.endStage(spec)
+
+	return nil
+}
+
+// Run runs the pipeline step in its own pod.
+func (e *PodPerStep) Run(ctx context.Context, specv runtime.Spec, stepv runtime.Step, output io.Writer) (*runtime.State, error) {
+	spec := specv.(*Spec)
+	step := stepv.(*Step)
+
+	log := logger.FromContext(ctx).
+		WithField("pod", step.ID).
+		WithField("namespace", spec.PodSpec.Namespace).
+		WithField("step", step.Name)
+
+	st, ok := e.stages.Load(spec.PodSpec.Name)
+	if !ok {
+		return nil, errPodStopped
+	}
+	pods := st.(*stepPods)
+
+	host := pods.hosts[step.Name]
+	if host != nil && step.Detach {
+		// the dependent steps must not wait for the service's address if its pod isn't created.
+		defer host.resolve("")
+	}
+
+	stepSpec, err := e.createStepPod(ctx, spec, step, pods, log)
+	if err != nil {
+		return nil, err
+	}
+
+	if host != nil && step.Detach {
+		host.start()
+		go e.resolveHost(stepSpec, host, log)
+	}
+
+	state, err := e.k.Run(ctx, stepSpec, step, output)
+
+	// the pods of the services are deleted by Destroy.
+	if !step.Detach {
+		if n := e.k.waitLogStreams(stepSpec.PodSpec.Name); n > 0 {
+			log.WithField("streams", n).Warn("timeout waiting for log streams to finish")
+		}
+
+		// if the pipeline is destroyed meanwhile, the pod is released by Destroy.
+		pods.mx.Lock()
+		_, owned := pods.pods[stepSpec.PodSpec.Name]
+		delete(pods.pods, stepSpec.PodSpec.Name)
+		pods.mx.Unlock()
+
+		if owned {
+			e.k.releasePod(stepSpec, log)
+		}
+	}
+
+	return state, err
+}
+
+// createStepPod creates the pod of the step. It returns the spec of the step pod, which is
+// the pipeline spec reduced to the step, and can be run by the Kubernetes engine.
+func (e *PodPerStep) createStepPod(ctx context.Context, spec *Spec, step *Step, pods *stepPods, log logger.Logger) (*Spec, error) {
+	stepSpec := *spec
+	stepSpec.PodSpec.Name = step.ID
+	stepSpec.PodSpec.HostAliases = e.hostAliases(spec, step, pods)
+	stepSpec.Steps = []*Step{step}
+	stepSpec.nativeSidecars = false
+	stepSpec.workspaceClaim = spec.PodSpec.Name
+
+	// the step pods run on the node of the first step pod, if the claim can be mounted only on a single node.
+	var isFirst bool
+	if e.workspace.AccessMode == v1.ReadWriteOnce {
+		isFirst = pods.node.place()
+		if !isFirst {
+			select {
+			case <-pods.node.ready:
+			case <-spec.stop:
+				return nil, errPodStopped
+			}
+			if node := pods.node.name; node != "" {
+				stepSpec.PodSpec.NodeSelector = map[string]string{labelHostname: node}
+				for k, v := range spec.PodSpec.NodeSelector {
+					stepSpec.PodSpec.NodeSelector[k] = v
+				}
+			}
+		}
+	}
+
+	pod := toStepPod(&stepSpec, spec.PodSpec.Name, e.workspace)
+
+	if _, err := e.k.client.CoreV1().Pods(spec.PodSpec.Namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		log.WithError(err).Error("failed to create step pod")
+		if isFirst {
+			pods.node.resolve("")
+		}
+		return nil, err
+	}
+	log.Trace("created step pod")
+
+	if isFirst {
+		go e.resolveNode(&stepSpec, pods.node, log)
+	}
+
+	pods.mx.Lock()
+	destroyed := pods.pods == nil
+	if !destroyed {
+		pods.pods[stepSpec.PodSpec.Name] = &stepSpec
+	}
+	pods.mx.Unlock()
+
+	if destroyed {
+		e.k.releasePod(&stepSpec, log)
+		return nil, errPodStopped
+	}
+
+	return &stepSpec, nil
+}
+
+// hostAliases returns the host aliases of the step's pod. The services are resolved to the addresses
+// of their pods, instead of to the loopback address of a pipeline pod. The step waits for the addresses
+// of the services it depends on, and of the services already started, which the steps of a graph
+// pipeline use without depending on them. The services not started yet can't be resolved.
+func (e *PodPerStep) hostAliases(spec *Spec, step *Step, pods *stepPods) []HostAlias {
+	var aliases []HostAlias
+	for _, alias := range spec.PodSpec.HostAliases {
+		if alias.IP != "127.0.0.1" {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	deps := make(map[string]bool)
+	for _, name := range dependencies(spec, step) {
+		deps[name] = true
+	}
+
+	timeout := time.NewTimer(e.k.podScheduleTimeout + e.k.containerStartTimeout)
+	defer timeout.Stop()
+
+	for _, s := range spec.Steps {
+		host, ok := pods.hosts[s.Name]
+		if !ok || s.Name == step.Name {
+			continue
+		}
+		if !deps[s.Name] && !host.isStarted() {
+			continue
+		}
+
+		select {
+		case <-host.ready:
+		case <-timeout.C:
+			return aliases
+		case <-spec.stop:
+			return aliases
+		}
+
+		if host.ip != "" {
+			aliases = append(aliases, HostAlias{IP: host.ip, Hostnames: []string{s.Name}})
+		}
+	}
+
+	return aliases
+}
+
+// resolveHost waits until the service's pod is assigned an IP address.
+func (e *PodPerStep) resolveHost(spec *Spec, host *serviceHost, log logger.Logger) {
+	defer host.resolve("")
+
+	timeout := time.NewTimer(e.k.podScheduleTimeout + e.k.containerStartTimeout)
+	defer timeout.Stop()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		pod, err := e.k.client.CoreV1().Pods(spec.PodSpec.Namespace).Get(context.Background(), spec.PodSpec.Name, metav1.GetOptions{})
+		if err == nil && pod.Status.PodIP != "" {
+			host.resolve(pod.Status.PodIP)
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timeout.C:
+			log.Warn("timeout waiting for service pod address")
+			return
+		case <-spec.stop:
+			return
+		}
+	}
+}
+
+// resolveNode waits until the first step pod is scheduled to a node.
+func (e *PodPerStep) resolveNode(spec *Spec, node *stageNode, log logger.Logger) {
+	defer node.resolve("")
+
+	timeout := time.NewTimer(e.k.podScheduleTimeout)
+	defer timeout.Stop()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		pod, err := e.k.client.CoreV1().Pods(spec.PodSpec.Namespace).Get(context.Background(), spec.PodSpec.Name, metav1.GetOptions{})
+		if err == nil && pod.Spec.NodeName != "" {
+			node.resolve(pod.Spec.NodeName)
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timeout.C:
+			log.Warn("timeout waiting for step pod to be scheduled")
+			return
+		case <-spec.stop:
+			return
+		}
+	}
+}
+
+// deleteClaim deletes the workspace claim, and with it the secrets it owns.
+func (e *PodPerStep) deleteClaim(spec *Spec, log logger.Logger) {
+	if err := e.k.client.CoreV1().PersistentVolumeClaims(spec.PodSpec.Namespace).Delete(context.Background(), spec.PodSpec.Name, metav1.DeleteOptions{}); err != nil {
+		log.WithError(err).Error("failed to delete workspace claim")
+	} else {
+		log.Trace("deleted workspace claim")
+	}
+}
+
+// dependencies returns names of all steps the step depends on, directly or indirectly.
+func dependencies(spec *Spec, step *Step) []string {
+	steps := make(map[string]*Step, len(spec.Steps))
+	for _, s := range spec.Steps {
+		steps[s.Name] = s
+	}
+
+	var names []string
+	visited := map[string]bool{step.Name: true}
+	queue := append([]string(nil), step.DependsOn...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+		names = append(names, name)
+
+		if s, ok := steps[name]; ok {
+			queue = append(queue, s.DependsOn...)
+		}
+	}
+
+	return names
+}
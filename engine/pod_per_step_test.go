@@ -0,0 +1,433 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine/podwatcher"
+
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/pipeline/runtime"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	fakerest "k8s.io/client-go/rest/fake"
+)
+
+func TestPodPerStep_SetupDestroy(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	e := NewPodPerStep(New(client, nil, nil, time.Minute, time.Minute, time.Minute, false), WorkspaceClaim{
+		Size:       resource.MustParse("1Gi"),
+		AccessMode: v1.ReadWriteMany,
+	})
+
+	spec := &Spec{
+		Steps: []*Step{
+			{ID: "drone-step", Name: "build", Secrets: []*SecretVar{{Name: "token", Env: "TOKEN"}}},
+		},
+		Secrets: map[string]*Secret{"token": {Name: "token", Data: "secret"}},
+	}
+	spec.PodSpec.Name = "drone-pod"
+	spec.PodSpec.Namespace = "default"
+
+	if err := e.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+
+	if !e.k.IsActive("drone-pod") {
+		t.Errorf("expected the pipeline to be active")
+	}
+
+	claim, err := client.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), "drone-pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := claim.Spec.AccessModes; !reflect.DeepEqual(got, []v1.PersistentVolumeAccessMode{v1.ReadWriteMany}) {
+		t.Errorf("unexpected access modes of the workspace claim: %v", got)
+	}
+
+	// the secrets are created per step, and owned by the workspace claim.
+	secret, err := client.CoreV1().Secrets("default").Get(context.Background(), "drone-step", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].Kind != "PersistentVolumeClaim" || secret.OwnerReferences[0].Name != "drone-pod" {
+		t.Errorf("expected the secret to be owned by the workspace claim, got %v", secret.OwnerReferences)
+	}
+
+	if err := e.Destroy(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), "drone-pod", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected the workspace claim to be deleted")
+	}
+
+	if e.k.IsActive("drone-pod") {
+		t.Errorf("expected the pipeline not to be active")
+	}
+}
+
+// logsClient is a fake kubernetes client that serves the container logs, which the fake
+// clientset can't, because the logs are streamed with its REST client.
+type logsClient struct {
+	*fake.Clientset
+}
+
+func (c *logsClient) CoreV1() corev1.CoreV1Interface {
+	return &logsCoreV1{c.Clientset.CoreV1()}
+}
+
+type logsCoreV1 struct {
+	corev1.CoreV1Interface
+}
+
+func (c *logsCoreV1) RESTClient() rest.Interface {
+	return &fakerest.RESTClient{
+		NegotiatedSerializer: scheme.Codecs.WithoutConversion(),
+		Client: fakerest.CreateHTTPClient(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}),
+	}
+}
+
+// This test verifies that a step pod is watched by its name, if the pod informer
+// doesn't cover the namespace, although it carries the labels of the pipeline.
+func TestPodPerStep_Run(t *testing.T) {
+	client := &logsClient{fake.NewSimpleClientset()}
+	e := NewPodPerStep(New(client, nil, nil, time.Minute, time.Minute, time.Minute, false), WorkspaceClaim{
+		Size:       resource.MustParse("1Gi"),
+		AccessMode: v1.ReadWriteMany,
+	})
+
+	step := &Step{ID: "drone-step", Name: "build", Image: "golang", Placeholder: "drone/placeholder:1"}
+	spec := &Spec{Steps: []*Step{step}}
+	spec.PodSpec.Name = "drone-pod"
+	spec.PodSpec.Namespace = "default"
+	spec.PodSpec.Labels = map[string]string{"io.drone.name": "drone-pod"}
+
+	ctx := context.Background()
+	if err := e.Setup(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Destroy(ctx, spec)
+
+	type result struct {
+		state *runtime.State
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		state, err := e.Run(ctx, spec, step, io.Discard)
+		done <- result{state: state, err: err}
+	}()
+
+	// the step's container is started once its image replaces the placeholder.
+	var pod *v1.Pod
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		p, err := client.CoreV1().Pods("default").Get(ctx, step.ID, metav1.GetOptions{})
+		if err == nil && p.Spec.Containers[0].Image == step.Image {
+			pod = p
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the step container to be launched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	pod.Spec.NodeName = "node"
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{{
+		Name:  step.ID,
+		Image: step.Image,
+		State: v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+	}}
+	if pod, _ = client.CoreV1().Pods("default").Update(ctx, pod, metav1.UpdateOptions{}); pod == nil {
+		t.Fatal("cannot update the step pod")
+	}
+
+	pod.Status.ContainerStatuses[0].State = v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 2}}
+	if _, err := client.CoreV1().Pods("default").Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if r.state == nil || r.state.ExitCode != 2 {
+			t.Errorf("want exit code 2, got %+v", r.state)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the step to finish")
+	}
+}
+
+func TestToStepPod(t *testing.T) {
+	step := &Step{
+		ID:          "drone-step",
+		Name:        "build",
+		Placeholder: "drone/placeholder:1",
+		Volumes: []*VolumeMount{
+			{Name: "_workspace", Path: "/drone/src"},
+			{Name: "dockersock", Path: "/var/run"},
+		},
+	}
+	spec := &Spec{
+		Steps: []*Step{step},
+		Volumes: []*Volume{
+			{EmptyDir: &VolumeEmptyDir{ID: "workspace", Name: "_workspace"}},
+			{EmptyDir: &VolumeEmptyDir{ID: "dockersock", Name: "dockersock"}},
+		},
+		workspaceClaim: "drone-pod",
+	}
+	spec.PodSpec.Name = "drone-step"
+
+	pod := toStepPod(spec, "drone-pod", WorkspaceClaim{AccessMode: v1.ReadWriteOnce})
+
+	// the workspace and the temporary volumes are shared by the step pods, in the directories of the claim.
+	if len(pod.Spec.Volumes) != 2 {
+		t.Fatalf("expected the workspace and the temporary volume, got %+v", pod.Spec.Volumes)
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil || volume.PersistentVolumeClaim.ClaimName != "drone-pod" {
+			t.Errorf("expected the volume %s to be mounted from the claim, got %+v", volume.Name, volume.VolumeSource)
+		}
+	}
+	want := []v1.VolumeMount{
+		{Name: "workspace", MountPath: "/drone/src", SubPath: "workspace"},
+		{Name: "dockersock", MountPath: "/var/run", SubPath: "volumes/dockersock"},
+	}
+	if got := pod.Spec.Containers[0].VolumeMounts; !reflect.DeepEqual(got, want) {
+		t.Errorf("want volume mounts %+v, got %+v", want, got)
+	}
+
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.PodAffinity == nil {
+		t.Fatalf("expected the step pod to be pinned to the node of the pipeline's pods")
+	}
+	term := pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0]
+	if term.LabelSelector.MatchLabels["io.drone.name"] != "drone-pod" || term.TopologyKey != "kubernetes.io/hostname" {
+		t.Errorf("unexpected pod affinity: %+v", term)
+	}
+
+	if pod := toStepPod(spec, "drone-pod", WorkspaceClaim{AccessMode: v1.ReadWriteMany}); pod.Spec.Affinity != nil {
+		t.Errorf("expected no affinity with a ReadWriteMany workspace")
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	spec := &Spec{
+		Steps: []*Step{
+			{Name: "clone"},
+			{Name: "database", DependsOn: []string{"clone"}},
+			{Name: "build", DependsOn: []string{"clone"}},
+			{Name: "test", DependsOn: []string{"build", "database"}},
+		},
+	}
+
+	got := dependencies(spec, spec.Steps[3])
+	sort.Strings(got)
+
+	if want := []string{"build", "clone", "database"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want dependencies %v, got %v", want, got)
+	}
+}
+
+// This test verifies that the step's secret is deleted, if the step's container fails to start.
+func TestPodPerStep_RunStartTimeout(t *testing.T) {
+	client := &logsClient{fake.NewSimpleClientset()}
+	e := NewPodPerStep(New(client, nil, nil, time.Second, time.Minute, time.Minute, false), WorkspaceClaim{
+		Size:       resource.MustParse("1Gi"),
+		AccessMode: v1.ReadWriteMany,
+	})
+
+	step := &Step{
+		ID:          "drone-step",
+		Name:        "build",
+		Image:       "golang",
+		Placeholder: "drone/placeholder:1",
+		Secrets:     []*SecretVar{{Name: "token", Env: "TOKEN"}},
+	}
+	spec := &Spec{
+		Steps:   []*Step{step},
+		Secrets: map[string]*Secret{"token": {Name: "token", Data: "secret"}},
+	}
+	spec.PodSpec.Name = "drone-pod"
+	spec.PodSpec.Namespace = "default"
+	spec.PodSpec.Labels = map[string]string{"io.drone.name": "drone-pod"}
+
+	ctx := context.Background()
+	if err := e.Setup(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Destroy(ctx, spec)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := e.Run(ctx, spec, step, io.Discard)
+		done <- err
+	}()
+
+	// the pod is scheduled, but the step's container never starts.
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		pod, err := client.CoreV1().Pods("default").Get(ctx, step.ID, metav1.GetOptions{})
+		if err == nil && pod.Spec.Containers[0].Image == step.Image {
+			pod.Spec.NodeName = "node"
+			if _, err := client.CoreV1().Pods("default").Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the step container to be launched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case err := <-done:
+		if _, ok := err.(podwatcher.StartTimeoutContainerError); !ok {
+			t.Errorf("want a start timeout error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the step to time out")
+	}
+
+	if _, err := client.CoreV1().Secrets("default").Get(ctx, step.ID, metav1.GetOptions{}); err == nil {
+		t.Errorf("expected the step secret to be deleted")
+	}
+}
+
+// This test verifies that the step pods are pinned to the node of the first
+// step pod, if the workspace claim can be mounted only on a single node.
+func TestPodPerStep_Node(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	e := NewPodPerStep(New(client, nil, nil, time.Minute, time.Minute, time.Minute, false), WorkspaceClaim{
+		Size:       resource.MustParse("1Gi"),
+		AccessMode: v1.ReadWriteOnce,
+	})
+
+	spec := &Spec{
+		Steps: []*Step{
+			{ID: "drone-step-1", Name: "build"},
+			{ID: "drone-step-2", Name: "test"},
+		},
+	}
+	spec.PodSpec.Name = "drone-pod"
+	spec.PodSpec.Namespace = "default"
+	spec.PodSpec.NodeSelector = map[string]string{"disktype": "ssd"}
+
+	ctx := context.Background()
+	if err := e.Setup(ctx, spec); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Destroy(ctx, spec)
+
+	v, _ := e.stages.Load("drone-pod")
+	pods := v.(*stepPods)
+
+	if _, err := e.createStepPod(ctx, spec, spec.Steps[0], pods, logger.Discard()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := e.createStepPod(ctx, spec, spec.Steps[1], pods, logger.Discard())
+		done <- err
+	}()
+
+	// the second step pod isn't created until the first one is scheduled.
+	select {
+	case err := <-done:
+		t.Fatalf("expected the step pod to wait for the node, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	pod, err := client.CoreV1().Pods("default").Get(ctx, "drone-step-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod.Spec.NodeName = "node-1"
+	if _, err := client.CoreV1().Pods("default").Update(ctx, pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the step pod to be created")
+	}
+
+	pod, err = client.CoreV1().Pods("default").Get(ctx, "drone-step-2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"disktype": "ssd", "kubernetes.io/hostname": "node-1"}
+	if got := pod.Spec.NodeSelector; !reflect.DeepEqual(got, want) {
+		t.Errorf("want node selector %v, got %v", want, got)
+	}
+	if got := spec.PodSpec.NodeSelector; len(got) != 1 {
+		t.Errorf("expected the pipeline's node selector to be unchanged, got %v", got)
+	}
+}
+
+func TestPodPerStep_HostAliases(t *testing.T) {
+	e := NewPodPerStep(New(fake.NewSimpleClientset(), nil, nil, time.Minute, time.Minute, time.Minute, false), WorkspaceClaim{})
+
+	// a graph pipeline, the test step doesn't depend on the services.
+	spec := &Spec{
+		Steps: []*Step{
+			{Name: "clone"},
+			{Name: "database", Detach: true, Service: true},
+			{Name: "redis", Detach: true, Service: true},
+			{Name: "test", DependsOn: []string{"clone"}},
+		},
+		PodSpec: PodSpec{
+			HostAliases: []HostAlias{
+				{IP: "127.0.0.1", Hostnames: []string{"database", "redis"}},
+				{IP: "10.0.0.1", Hostnames: []string{"registry"}},
+			},
+		},
+		stop: make(chan struct{}),
+	}
+
+	pods := &stepPods{
+		pods: map[string]*Spec{},
+		hosts: map[string]*serviceHost{
+			"database": newServiceHost(),
+			"redis":    newServiceHost(),
+		},
+	}
+
+	// the database is started, the redis service isn't started yet.
+	pods.hosts["database"].start()
+	go pods.hosts["database"].resolve("10.1.0.5")
+
+	got := e.hostAliases(spec, spec.Steps[3], pods)
+	want := []HostAlias{
+		{IP: "10.0.0.1", Hostnames: []string{"registry"}},
+		{IP: "10.1.0.5", Hostnames: []string{"database"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want host aliases %v, got %v", want, got)
+	}
+}
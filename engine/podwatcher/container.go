@@ -6,31 +6,39 @@ package podwatcher
 
 import (
 	"context"
-	"fmt"
+	"strings"
 	"time"
+
+	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
 )
 
 type ContainerWatcher interface {
 	// Name returns name of the pod that contains the containers
 	Name() string
 
-	// Watch waits for updates of the containers and puts the updated data to the channel passed as a parameter.
+	// Watch waits for updates of the pod and its containers and puts the updated data to the channel passed as a parameter.
 	// It should finish either when the context is done or when no more events are expected.
-	Watch(ctx context.Context, containers chan<- []containerInfo) error
+	Watch(ctx context.Context, pods chan<- podInfo) error
 
-	// PeriodicCheck should periodically put the current state of the containers to the channel.
+	// PeriodicCheck should periodically put the current state of the pod and its containers to the channel.
 	// It should finish either when the context is done or when the stop channel is closed.
 	// To disable the feature, the implementation should be an empty function.
-	PeriodicCheck(ctx context.Context, containers chan<- []containerInfo, stop <-chan struct{}) error
+	PeriodicCheck(ctx context.Context, pods chan<- podInfo, stop <-chan struct{}) error
 }
 
 // waitClient is a process that waits for state of a container (with id = containerId) to change to containerState.
 // It is resolved by writing an error value to the resolveCh channel, or nil if no error occurred.
-// If containerId is an empty string, the process waits for whole the pod to finish.
+// If containerId is an empty string, the process waits for whole the pod to finish,
+// unless waitForScheduled is set, in which case the process waits for the pod to be scheduled to a node.
 type waitClient struct {
-	containerId  string
-	waitForState stepState
-	resolveCh    chan error
+	containerId      string
+	waitForState     stepState
+	waitForScheduled bool
+	resolveCh        chan error
+
+	// termination is populated with the container termination info before the client
+	// waiting for stepStateFinished is resolved.
+	termination Termination
 }
 
 // containerRegInfo is used by the PodWatcher register new containers to watch.
@@ -40,21 +48,57 @@ type containerRegInfo struct {
 	image       string
 }
 
+// podInfo is used by the ContainerWatcher to send info about the pod and its containers to PodWatcher.
+type podInfo struct {
+	phase string
+
+	// reason and message explain why the pod is in its phase, for example "Evicted" and the eviction message.
+	reason  string
+	message string
+
+	// deleting is true if the pod has a deletion timestamp, i.e. it's being deleted.
+	deleting bool
+
+	// sidecars holds the names of the service mesh proxy containers injected into the pod.
+	sidecars []string
+
+	// scheduled is true if the pod is assigned to a node.
+	scheduled bool
+
+	// unschedulableMessage holds the reason, provided by the scheduler, why the pod can't be scheduled.
+	unschedulableMessage string
+
+	containers []containerInfo
+}
+
 // containerInfo is used by the ContainerWatcher to send info about a container to PodWatcher.
 type containerInfo struct {
 	id           string
 	state        containerState
 	image        string
+	imageID      string
 	exitCode     int32
 	reason       string
 	restartCount int32
 	ready        bool
+
+	signal     int32
+	message    string
+	podReason  string
+	startedAt  time.Time
+	finishedAt time.Time
+
+	// specImage is the image of the container in the pod's spec, i.e. the requested image.
+	specImage string
 }
 
 func (info *containerInfo) stateToMap() (m map[string]interface{}) {
 	m = make(map[string]interface{})
 	m["state"] = info.state.String()
 	m["image"] = info.image
+	if info.specImage != "" && info.specImage != info.image {
+		m["specImage"] = info.specImage
+	}
 	if info.exitCode != 0 {
 		m["exitCode"] = info.exitCode
 	}
@@ -64,6 +108,12 @@ func (info *containerInfo) stateToMap() (m map[string]interface{}) {
 	if info.restartCount != 0 {
 		m["restartCount"] = info.restartCount
 	}
+	if info.signal != 0 {
+		m["signal"] = info.signal
+	}
+	if info.podReason != "" {
+		m["podReason"] = info.podReason
+	}
 	if !info.ready {
 		m["ready"] = "true"
 	}
@@ -81,8 +131,111 @@ type containerWatchInfo struct {
 	exitCode int32
 	reason   string
 
-	addedAt  time.Time
-	failedAt time.Time
+	signal     int32
+	message    string
+	podReason  string
+	startedAt  time.Time
+	finishedAt time.Time
+
+	addedAt time.Time
+
+	// failedImage is the image that couldn't be pulled.
+	failedImage string
+}
+
+// failure returns the error with which the wait clients of a failed container are resolved.
+func (c *containerWatchInfo) failure() error {
+	if isImageErrorReason(c.reason) {
+		return ImagePullError{
+			Container: c.id,
+			Image:     c.failedImage,
+			Reason:    c.reason,
+			Message:   c.message,
+		}
+	}
+
+	return FailedContainerError{
+		container: c.id,
+		exitCode:  c.exitCode,
+		reason:    c.reason,
+		image:     c.image,
+	}
+}
+
+// isImageErrorReason returns true if the reason, why a container is waiting, means that the container's image
+// can't be pulled or the container can't be created. Kubernetes keeps retrying, but it rarely succeeds.
+func isImageErrorReason(reason string) bool {
+	switch reason {
+	case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError":
+		return true
+	default:
+		return false
+	}
+}
+
+// isStepImage returns true if the container status reports the step's image,
+// either as the container's image or as the container's image ID.
+func isStepImage(cs containerInfo, stepImage string) bool {
+	if image.Match(cs.image, stepImage) {
+		return true
+	}
+
+	// the image ID is the image's digest, usually prefixed with the repository
+	// name and a scheme, for example "docker-pullable://golang@sha256:...".
+	imageID := cs.imageID
+	if i := strings.Index(imageID, "://"); i >= 0 {
+		imageID = imageID[i+3:]
+	}
+
+	return imageID != "" && image.Match(imageID, stepImage)
+}
+
+func (c *containerWatchInfo) termination() Termination {
+	return Termination{
+		ExitCode:   int(c.exitCode),
+		Reason:     c.reason,
+		Signal:     int(c.signal),
+		Message:    c.message,
+		PodReason:  c.podReason,
+		StartedAt:  c.startedAt,
+		FinishedAt: c.finishedAt,
+	}
+}
+
+// Termination holds details about a terminated container.
+type Termination struct {
+	// ExitCode is the exit code of the container process.
+	ExitCode int
+
+	// Reason is the reason of the termination reported by Kubernetes, for example "OOMKilled" or "Error".
+	Reason string
+
+	// Signal is the signal that terminated the container process, if any.
+	Signal int
+
+	// Message is the termination message of the container.
+	Message string
+
+	// PodReason is the reason of the pod status, for example "Evicted" or "DeadlineExceeded".
+	PodReason string
+
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// OOMKilled returns true if the container was killed because it exceeded the memory limit.
+func (t Termination) OOMKilled() bool {
+	return t.Reason == "OOMKilled"
+}
+
+// Evicted returns true if the pod of the container was evicted from the node.
+func (t Termination) Evicted() bool {
+	return t.Reason == "Evicted" || t.PodReason == "Evicted"
+}
+
+// DeadlineExceeded returns true if the container was killed because the pod's active deadline was exceeded.
+func (t Termination) DeadlineExceeded() bool {
+	return t.Reason == "DeadlineExceeded" || t.PodReason == "DeadlineExceeded"
 }
 
 type containerState int
@@ -133,10 +286,3 @@ func (s stepState) String() string {
 		panic("unsupported containerInfo state")
 	}
 }
-
-// exitCodeError is used to return exit code of a terminated container if the exit code is not zero.
-type exitCodeError int
-
-func (e exitCodeError) Error() string {
-	return fmt.Sprintf("exitCode=%d", e)
-}
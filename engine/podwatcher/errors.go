@@ -49,6 +49,28 @@ func (e StartTimeoutContainerError) Error() string {
 		e.Container, e.Image)
 }
 
+// ImagePullError is returned as an error when the image of a container can't be pulled,
+// or the container can't be created. Message holds the reason provided by Kubernetes,
+// usually the registry's response.
+type ImagePullError struct {
+	Container string
+	Image     string
+	Reason    string
+	Message   string
+}
+
+func (e ImagePullError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf(
+			"kubernetes has failed: container failed to start: id=%s reason=%s image=%s",
+			e.Container, e.Reason, e.Image)
+	}
+
+	return fmt.Sprintf(
+		"kubernetes has failed: container failed to start: id=%s reason=%s image=%s: %s",
+		e.Container, e.Reason, e.Image, e.Message)
+}
+
 // OtherContainerError is returned as an error by wait function when some other container
 // in the same pod fails with a "kubernetes has failed" error.
 type OtherContainerError struct {
@@ -60,3 +82,81 @@ func (e OtherContainerError) Error() string {
 		"aborting due to error: %s",
 		e.Err)
 }
+
+// UnschedulableError is returned as an error when the pod is not scheduled to a node after some predefined time.
+// Message holds the reason provided by the Kubernetes scheduler, if any.
+type UnschedulableError struct {
+	Pod     string
+	Message string
+}
+
+func (e UnschedulableError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf(
+			"kubernetes has failed: pod was not scheduled in timely manner: pod=%s",
+			e.Pod)
+	}
+
+	return fmt.Sprintf(
+		"kubernetes has failed: pod could not be scheduled: pod=%s: %s",
+		e.Pod, e.Message)
+}
+
+// PodEvictedError is returned as an error when the pod fails, is evicted from its node,
+// or is deleted, while a container is expected to start or terminate.
+type PodEvictedError struct {
+	Pod     string
+	Phase   string
+	Reason  string
+	Message string
+}
+
+func (e PodEvictedError) Error() string {
+	var what string
+	switch e.Reason {
+	case "Evicted":
+		what = "pod was evicted from the node"
+	case "Preempting":
+		what = "pod was preempted by a pod with higher priority"
+	case "NodeLost":
+		what = "node running the pod is lost"
+	case "Deleted":
+		what = "pod was deleted"
+	default:
+		what = "pod has failed"
+	}
+
+	s := fmt.Sprintf("kubernetes has failed: %s: pod=%s phase=%s", what, e.Pod, e.Phase)
+	if e.Reason != "" {
+		s += " reason=" + e.Reason
+	}
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+
+	return s
+}
+
+// ErrorType returns the name of the error's type, or an empty string if the error is not defined by this package.
+func ErrorType(err error) string {
+	switch err.(type) {
+	case UnknownContainerError:
+		return "unknown_container"
+	case PodTerminatedError:
+		return "pod_terminated"
+	case FailedContainerError:
+		return "failed_container"
+	case StartTimeoutContainerError:
+		return "start_timeout"
+	case ImagePullError:
+		return "image_pull"
+	case OtherContainerError:
+		return "other_container"
+	case UnschedulableError:
+		return "unschedulable"
+	case PodEvictedError:
+		return "pod_evicted"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package podwatcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// EventWatcher watches Kubernetes events related to a pod and writes them to the output of the steps
+// that are waiting for their containers to start. Events related to a container are written only to
+// the output of the container's step. Pod level warnings are written to the output of all waiting steps.
+type EventWatcher struct {
+	stop, stopped chan struct{}
+
+	kubeClient kubernetes.Interface
+
+	podNamespace string
+	podName      string
+
+	mx      sync.Mutex
+	outputs map[string]eventOutput
+}
+
+// eventOutput is a step output registered to receive events.
+type eventOutput struct {
+	w     io.Writer
+	since time.Time
+}
+
+// NewEventWatcher creates a new EventWatcher.
+func NewEventWatcher(podName, podNamespace string, clientset kubernetes.Interface) *EventWatcher {
+	return &EventWatcher{
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		kubeClient:   clientset,
+		podNamespace: podNamespace,
+		podName:      podName,
+		outputs:      make(map[string]eventOutput),
+	}
+}
+
+// Start starts EventWatcher's main go routine.
+func (w *EventWatcher) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		<-w.stop
+		cancel()
+	}()
+
+	go func() {
+		defer close(w.stopped)
+
+		selector := fields.Set{
+			"involvedObject.kind": "Pod",
+			"involvedObject.name": w.podName,
+		}.AsSelector().String()
+
+		lw := &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (k8sruntime.Object, error) {
+				options.FieldSelector = selector
+				return w.kubeClient.CoreV1().Events(w.podNamespace).List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = selector
+				return w.kubeClient.CoreV1().Events(w.podNamespace).Watch(ctx, options)
+			},
+		}
+
+		_, err := watchtools.UntilWithSync(ctx, lw, &v1.Event{}, nil, func(event watch.Event) (bool, error) {
+			if event.Type != watch.Added && event.Type != watch.Modified {
+				return false, nil
+			}
+
+			if e, ok := event.Object.(*v1.Event); ok {
+				w.dispatch(e)
+			}
+
+			return false, nil
+		})
+
+		if err != nil && ctx.Err() == nil {
+			logrus.
+				WithError(err).
+				WithField("pod", w.podName).
+				WithField("namespace", w.podNamespace).
+				Warn("EventWatcher: Failed to watch")
+		}
+	}()
+}
+
+// Stop terminates EventWatcher's main go routine.
+func (w *EventWatcher) Stop() {
+	close(w.stop)
+	<-w.stopped
+}
+
+// Register starts writing events related to the container to the output.
+func (w *EventWatcher) Register(containerId string, output io.Writer) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	// event timestamps have a precision of one second
+	w.outputs[containerId] = eventOutput{w: output, since: time.Now().Truncate(time.Second)}
+}
+
+// Unregister stops writing events to the container's output.
+// After the method returns, nothing will be written to the output.
+func (w *EventWatcher) Unregister(containerId string) {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	delete(w.outputs, containerId)
+}
+
+func (w *EventWatcher) dispatch(e *v1.Event) {
+	containerId := containerFromFieldPath(e.InvolvedObject.FieldPath)
+	if containerId == "" && e.Type != v1.EventTypeWarning {
+		return // pod level events are interesting only if they are warnings
+	}
+
+	line := formatEvent(e)
+	t := eventTime(e)
+
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	for id, output := range w.outputs {
+		if containerId != "" && containerId != id {
+			continue
+		}
+
+		if t.Before(output.since) {
+			continue
+		}
+
+		_, _ = io.WriteString(output.w, line)
+	}
+}
+
+// containerFromFieldPath extracts container name from a field path, for example
+// from "spec.containers{drone-abc}" it returns "drone-abc".
+func containerFromFieldPath(fieldPath string) string {
+	for _, prefix := range []string{"spec.containers{", "spec.initContainers{"} {
+		if strings.HasPrefix(fieldPath, prefix) && strings.HasSuffix(fieldPath, "}") {
+			return fieldPath[len(prefix) : len(fieldPath)-1]
+		}
+	}
+	return ""
+}
+
+// eventTime returns the time when the event last occurred.
+func eventTime(e *v1.Event) time.Time {
+	switch {
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	default:
+		return e.FirstTimestamp.Time
+	}
+}
+
+func formatEvent(e *v1.Event) string {
+	return fmt.Sprintf("[kubernetes] %s %s: %s\n", e.Type, e.Reason, strings.TrimSpace(e.Message))
+}
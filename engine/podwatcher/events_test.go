@@ -0,0 +1,90 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package podwatcher
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEventWatcher_Dispatch(t *testing.T) {
+	w := NewEventWatcher("pod", "default", nil)
+
+	bufA := &bytes.Buffer{}
+	bufB := &bytes.Buffer{}
+
+	w.Register("A", bufA)
+	w.Register("B", bufB)
+
+	now := metav1.NewTime(time.Now())
+	old := metav1.NewTime(time.Now().Add(-time.Hour))
+
+	w.dispatch(&v1.Event{
+		InvolvedObject: v1.ObjectReference{FieldPath: "spec.containers{A}"},
+		Type:           v1.EventTypeNormal,
+		Reason:         "Pulling",
+		Message:        `Pulling image "golang"`,
+		LastTimestamp:  now,
+	})
+	w.dispatch(&v1.Event{
+		InvolvedObject: v1.ObjectReference{FieldPath: "spec.containers{B}"},
+		Type:           v1.EventTypeNormal,
+		Reason:         "Pulling",
+		Message:        `Pulling image "placeholder"`,
+		LastTimestamp:  old,
+	})
+	w.dispatch(&v1.Event{
+		Type:          v1.EventTypeNormal,
+		Reason:        "Scheduled",
+		Message:       "Successfully assigned default/pod to node",
+		LastTimestamp: now,
+	})
+	w.dispatch(&v1.Event{
+		Type:          v1.EventTypeWarning,
+		Reason:        "FailedMount",
+		Message:       "MountVolume.SetUp failed",
+		LastTimestamp: now,
+	})
+
+	w.Unregister("B")
+
+	w.dispatch(&v1.Event{
+		InvolvedObject: v1.ObjectReference{FieldPath: "spec.containers{B}"},
+		Type:           v1.EventTypeWarning,
+		Reason:         "Failed",
+		Message:        "ErrImagePull",
+		LastTimestamp:  now,
+	})
+
+	wantA := "[kubernetes] Normal Pulling: Pulling image \"golang\"\n" +
+		"[kubernetes] Warning FailedMount: MountVolume.SetUp failed\n"
+	if got := bufA.String(); got != wantA {
+		t.Errorf("want %q, got %q", wantA, got)
+	}
+
+	wantB := "[kubernetes] Warning FailedMount: MountVolume.SetUp failed\n"
+	if got := bufB.String(); got != wantB {
+		t.Errorf("want %q, got %q", wantB, got)
+	}
+}
+
+func TestContainerFromFieldPath(t *testing.T) {
+	tests := map[string]string{
+		"spec.containers{drone-abc}":     "drone-abc",
+		"spec.initContainers{drone-xyz}": "drone-xyz",
+		"spec.containers":                "",
+		"":                               "",
+	}
+
+	for fieldPath, want := range tests {
+		if got := containerFromFieldPath(fieldPath); got != want {
+			t.Errorf("for %q want %q, got %q", fieldPath, want, got)
+		}
+	}
+}
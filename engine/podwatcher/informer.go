@@ -0,0 +1,264 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package podwatcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// PodInformer watches all pipeline pods, the pods labeled with io.drone=true, with a single
+// shared informer, and fans out the updates to the ContainerWatchers created by its Watcher method.
+// It replaces a list/watch request and periodic get requests per pod with a single list/watch request.
+type PodInformer struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+	informer   cache.SharedIndexInformer
+
+	mx          sync.Mutex
+	subscribers map[string]*podSubscriber
+}
+
+// podSubscriber holds the latest state of a pod until it's consumed by the pod's ContainerWatcher.
+type podSubscriber struct {
+	notify chan struct{}
+
+	mx      sync.Mutex
+	pod     *v1.Pod
+	deleted bool
+}
+
+// NewPodInformer creates a new PodInformer. If the namespace is empty, pods in all namespaces are watched.
+func NewPodInformer(clientset kubernetes.Interface, namespace string) *PodInformer {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = "io.drone=true"
+		}))
+
+	i := &PodInformer{
+		kubeClient:  clientset,
+		namespace:   namespace,
+		informer:    factory.Core().V1().Pods().Informer(),
+		subscribers: make(map[string]*podSubscriber),
+	}
+
+	i.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			i.dispatch(obj, false)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			i.dispatch(obj, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			i.dispatch(obj, true)
+		},
+	})
+
+	return i
+}
+
+// Start starts the informer. It runs until the context is done.
+func (i *PodInformer) Start(ctx context.Context) {
+	go i.informer.Run(ctx.Done())
+}
+
+// Covers returns true if the informer watches pods in the namespace.
+func (i *PodInformer) Covers(namespace string) bool {
+	return i.namespace == "" || i.namespace == namespace
+}
+
+// Watcher returns a ContainerWatcher for the pod.
+func (i *PodInformer) Watcher(podNamespace, podName string, period time.Duration) ContainerWatcher {
+	return &informerWatcher{
+		informer:     i,
+		podNamespace: podNamespace,
+		podName:      podName,
+		period:       period,
+	}
+}
+
+func (i *PodInformer) dispatch(obj interface{}, deleted bool) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return
+	}
+
+	i.mx.Lock()
+	s := i.subscribers[podKey(pod.Namespace, pod.Name)]
+	i.mx.Unlock()
+
+	if s == nil {
+		return
+	}
+
+	s.mx.Lock()
+	s.pod = pod
+	s.deleted = s.deleted || deleted
+	s.mx.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default: // the subscriber is already notified
+	}
+}
+
+func (i *PodInformer) subscribe(key string) *podSubscriber {
+	s := &podSubscriber{notify: make(chan struct{}, 1)}
+
+	i.mx.Lock()
+	i.subscribers[key] = s
+	i.mx.Unlock()
+
+	return s
+}
+
+func (i *PodInformer) unsubscribe(key string, s *podSubscriber) {
+	i.mx.Lock()
+	if i.subscribers[key] == s {
+		delete(i.subscribers, key)
+	}
+	i.mx.Unlock()
+}
+
+// get returns the pod from the informer's cache.
+func (i *PodInformer) get(key string) *v1.Pod {
+	obj, exists, err := i.informer.GetStore().GetByKey(key)
+	if err != nil || !exists {
+		return nil
+	}
+
+	pod, _ := obj.(*v1.Pod)
+	return pod
+}
+
+func podKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// informerWatcher implements ContainerWatcher interface by receiving the pod updates from a PodInformer.
+type informerWatcher struct {
+	informer     *PodInformer
+	podNamespace string
+	podName      string
+	period       time.Duration
+}
+
+func (w *informerWatcher) Name() string {
+	return w.podName
+}
+
+// Watch is a part of ContainerWatcher implementation for the informerWatcher struct.
+// The method will run until the pod is deleted.
+func (w *informerWatcher) Watch(ctx context.Context, pods chan<- podInfo) error {
+	key := podKey(w.podNamespace, w.podName)
+
+	s := w.informer.subscribe(key)
+	defer w.informer.unsubscribe(key, s)
+
+	// a newly created pod might not be in the informer's cache yet, so it's read from the Kubernetes API.
+	pod := w.informer.get(key)
+	if pod == nil {
+		var err error
+		pod, err = w.informer.kubeClient.CoreV1().Pods(w.podNamespace).Get(ctx, w.podName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return nil // the pod is already deleted
+		} else if err != nil {
+			logrus.WithContext(ctx).
+				WithError(err).
+				WithField("pod", w.podName).
+				WithField("namespace", w.podNamespace).
+				Warn("PodWatcher: Failed to read pod")
+			pod = nil
+		}
+	}
+
+	if pod != nil {
+		select {
+		case pods <- extractPod(pod):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-s.notify:
+			s.mx.Lock()
+			pod, deleted := s.pod, s.deleted
+			s.mx.Unlock()
+
+			logrus.WithContext(ctx).
+				WithField("pod", w.podName).
+				WithField("deleted", deleted).
+				Trace("PodWatcher: Informer event")
+
+			if deleted {
+				return nil // stop listening to further events
+			}
+
+			select {
+			case pods <- extractPod(pod):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// PeriodicCheck is a part of ContainerWatcher implementation for the informerWatcher struct.
+// The pod is read from the informer's cache, so it doesn't make requests to the Kubernetes API.
+func (w *informerWatcher) PeriodicCheck(ctx context.Context, pods chan<- podInfo, stop <-chan struct{}) error {
+	if w.period == 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(w.period)
+	defer ticker.Stop()
+
+	key := podKey(w.podNamespace, w.podName)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-stop:
+			return nil
+
+		case <-ticker.C:
+			pod := w.informer.get(key)
+			if pod == nil {
+				continue
+			}
+
+			logrus.
+				WithField("pod", w.podName).
+				WithField("namespace", w.podNamespace).
+				Trace("PodWatcher: Periodic container state check")
+
+			select {
+			case pods <- extractPod(pod):
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package podwatcher
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPodInformer_Watch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "drone-1",
+			Namespace: "default",
+			Labels:    map[string]string{"io.drone": "true"},
+		},
+		Status: v1.PodStatus{Phase: v1.PodPending},
+	}
+
+	client := fake.NewSimpleClientset(pod)
+
+	informer := NewPodInformer(client, "default")
+	informer.Start(ctx)
+
+	// the fake clientset doesn't replay the changes made between the informer's list and watch requests.
+	for !isWatching(client) {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for the informer to watch pods")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if !informer.Covers("default") || informer.Covers("other") {
+		t.Errorf("unexpected namespaces covered by the informer")
+	}
+
+	pods := make(chan podInfo)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- informer.Watcher("default", "drone-1", 0).Watch(ctx, pods)
+	}()
+
+	receive := func(phase v1.PodPhase) {
+		for {
+			select {
+			case info := <-pods:
+				if info.phase == string(phase) {
+					return
+				}
+			case <-ctx.Done():
+				t.Fatalf("timeout waiting for pod phase %s", phase)
+			}
+		}
+	}
+
+	receive(v1.PodPending)
+
+	running := pod.DeepCopy()
+	running.Status.Phase = v1.PodRunning
+	if _, err := client.CoreV1().Pods("default").UpdateStatus(ctx, running, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	receive(v1.PodRunning)
+
+	if err := client.CoreV1().Pods("default").Delete(ctx, "drone-1", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		select {
+		case <-pods: // drain the updates sent before the pod is deleted
+		case err := <-errCh:
+			if err != nil {
+				t.Errorf("expected the watch to end without error, got %s", err)
+			}
+			return
+		case <-ctx.Done():
+			t.Fatal("timeout waiting for the watch to end after the pod is deleted")
+		}
+	}
+}
+
+func isWatching(client *fake.Clientset) bool {
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "watch" && action.GetResource().Resource == "pods" {
+			return true
+		}
+	}
+	return false
+}
@@ -8,9 +8,12 @@ import (
 	"context"
 	"time"
 
+	"github.com/drone-runners/drone-runner-kube/engine/mesh"
+
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
@@ -33,16 +36,18 @@ func (w *KubernetesWatcher) Name() string {
 // Watch is a part of ContainerWatcher implementation for the KubernetesWatcher struct.
 // It will create a Kubernetes watcher that watches all events coming from a specific pod.
 // The method will run until the pod terminates and is deleted (until the "Deleted" event arrives).
-func (w *KubernetesWatcher) Watch(ctx context.Context, containers chan<- []containerInfo) error {
-	label := "io.drone.name=" + w.PodName
+func (w *KubernetesWatcher) Watch(ctx context.Context, pods chan<- podInfo) error {
+	// the pod is selected by its name, because the step pods of a pipeline
+	// carry the pipeline's labels, including the io.drone.name label.
+	field := fields.OneTermEqualSelector("metadata.name", w.PodName).String()
 
 	lw := &cache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (k8sruntime.Object, error) {
-			options.LabelSelector = label
+			options.FieldSelector = field
 			return w.KubeClient.CoreV1().Pods(w.PodNamespace).List(ctx, options)
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-			options.LabelSelector = label
+			options.FieldSelector = field
 			return w.KubeClient.CoreV1().Pods(w.PodNamespace).Watch(ctx, options)
 		},
 	}
@@ -68,7 +73,7 @@ func (w *KubernetesWatcher) Watch(ctx context.Context, containers chan<- []conta
 			return true, nil // stop listening to further events
 		}
 
-		containers <- extractContainers(pod)
+		pods <- extractPod(pod)
 
 		return false, nil
 	})
@@ -85,7 +90,7 @@ func (w *KubernetesWatcher) Watch(ctx context.Context, containers chan<- []conta
 }
 
 // PeriodicCheck is a part of ContainerWatcher implementation for the KubernetesWatcher struct.
-func (w *KubernetesWatcher) PeriodicCheck(ctx context.Context, containers chan<- []containerInfo, stop <-chan struct{}) error {
+func (w *KubernetesWatcher) PeriodicCheck(ctx context.Context, pods chan<- podInfo, stop <-chan struct{}) error {
 	if w.Period == 0 {
 		return nil
 	}
@@ -117,46 +122,136 @@ func (w *KubernetesWatcher) PeriodicCheck(ctx context.Context, containers chan<-
 				WithField("namespace", w.PodNamespace).
 				Trace("PodWatcher: Periodic container state check")
 
-			containers <- extractContainers(pod)
+			pods <- extractPod(pod)
 		}
 	}
 }
 
+func extractPod(pod *v1.Pod) (result podInfo) {
+	if pod == nil {
+		return
+	}
+
+	result.phase = string(pod.Status.Phase)
+	result.reason = pod.Status.Reason
+	result.message = pod.Status.Message
+	result.deleting = pod.DeletionTimestamp != nil
+
+	// A pod with the node name already set (for example with the pipeline's node_name) bypasses the scheduler.
+	result.scheduled = pod.Spec.NodeName != ""
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type != v1.PodScheduled {
+			continue
+		}
+
+		if cond.Status == v1.ConditionTrue {
+			result.scheduled = true
+		} else if cond.Reason == v1.PodReasonUnschedulable {
+			result.unschedulableMessage = cond.Message
+		}
+	}
+
+	// the proxies are injected either as regular containers, or as init containers that keep running.
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			if mesh.IsProxy(c.Name) {
+				result.sidecars = append(result.sidecars, c.Name)
+			}
+		}
+	}
+
+	result.containers = extractContainers(pod)
+
+	return
+}
+
 func extractContainers(pod *v1.Pod) (result []containerInfo) {
 	if pod == nil {
 		return
 	}
 
-	result = make([]containerInfo, len(pod.Status.ContainerStatuses))
+	// the services run as native sidecar containers are init containers, and the debug containers are
+	// ephemeral containers, so their statuses are included too. The statuses of the containers that
+	// aren't registered with the watcher are ignored.
+	statuses := make([]v1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+
+		len(pod.Status.ContainerStatuses)+len(pod.Status.EphemeralContainerStatuses))
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	statuses = append(statuses, pod.Status.EphemeralContainerStatuses...)
+
+	initContainers := make(map[string]bool, len(pod.Status.InitContainerStatuses))
+	for _, cs := range pod.Status.InitContainerStatuses {
+		initContainers[cs.Name] = true
+	}
+
+	result = make([]containerInfo, 0, len(statuses))
+
+	specImages := make(map[string]string, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	for _, containers := range [][]v1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, c := range containers {
+			specImages[c.Name] = c.Image
+		}
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		specImages[c.Name] = c.Image
+	}
+
+	for _, cs := range statuses {
+		// the service mesh proxies never exit, they are not pipeline steps.
+		if mesh.IsProxy(cs.Name) {
+			continue
+		}
+
+		// the init containers of a pipeline pod are restarted only if they are native sidecars,
+		// which kubernetes restarts whenever they exit. The service is finished when it exits
+		// the first time, so its last termination is reported instead of the restarted container.
+		if initContainers[cs.Name] && cs.RestartCount > 0 && cs.State.Terminated == nil && cs.LastTerminationState.Terminated != nil {
+			cs.State = cs.LastTerminationState
+		}
 
-	for i, cs := range pod.Status.ContainerStatuses {
 		var (
 			state    containerState
 			reason   string
 			exitCode int32
+			signal   int32
+			message  string
+
+			startedAt, finishedAt time.Time
 		)
 
-		if cs.State.Terminated != nil {
-			state, reason = stateTerminated, cs.State.Terminated.Reason
-			exitCode = cs.State.Terminated.ExitCode
+		if t := cs.State.Terminated; t != nil {
+			state, reason = stateTerminated, t.Reason
+			exitCode = t.ExitCode
+			signal = t.Signal
+			message = t.Message
+			startedAt, finishedAt = t.StartedAt.Time, t.FinishedAt.Time
 		} else if cs.State.Running != nil {
 			state, reason = stateRunning, ""
 		} else if cs.State.Waiting != nil {
 			state, reason = stateWaiting, cs.State.Waiting.Reason
+			message = cs.State.Waiting.Message
 		} else {
 			// kubernetes doc explains that this situation should be treated as Waiting state
 			state, reason = stateWaiting, ""
 		}
 
-		result[i] = containerInfo{
+		result = append(result, containerInfo{
 			id:           cs.Name,
 			state:        state,
 			image:        cs.Image,
+			imageID:      cs.ImageID,
 			exitCode:     exitCode,
 			reason:       reason,
 			restartCount: cs.RestartCount,
 			ready:        cs.Ready,
-		}
+			signal:       signal,
+			message:      message,
+			podReason:    pod.Status.Reason,
+			startedAt:    startedAt,
+			finishedAt:   finishedAt,
+			specImage:    specImages[cs.Name],
+		})
 	}
 
 	return
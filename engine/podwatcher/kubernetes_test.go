@@ -0,0 +1,247 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package podwatcher
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExtractContainers_Terminated(t *testing.T) {
+	started := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	finished := started.Add(time.Minute)
+
+	pod := &v1.Pod{
+		Status: v1.PodStatus{
+			Reason: "Evicted",
+			ContainerStatuses: []v1.ContainerStatus{
+				{
+					Name:  "A",
+					Image: "golang",
+					State: v1.ContainerState{
+						Terminated: &v1.ContainerStateTerminated{
+							ExitCode:   137,
+							Signal:     9,
+							Reason:     "OOMKilled",
+							Message:    "out of memory",
+							StartedAt:  metav1.NewTime(started),
+							FinishedAt: metav1.NewTime(finished),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	containers := extractContainers(pod)
+	if len(containers) != 1 {
+		t.Fatalf("expected one container, got %d", len(containers))
+	}
+
+	c := containers[0]
+	if c.state != stateTerminated {
+		t.Errorf("expected terminated state, got %s", c.state)
+	}
+
+	w := &containerWatchInfo{
+		exitCode:   c.exitCode,
+		reason:     c.reason,
+		signal:     c.signal,
+		message:    c.message,
+		podReason:  c.podReason,
+		startedAt:  c.startedAt,
+		finishedAt: c.finishedAt,
+	}
+
+	want := Termination{
+		ExitCode:   137,
+		Reason:     "OOMKilled",
+		Signal:     9,
+		Message:    "out of memory",
+		PodReason:  "Evicted",
+		StartedAt:  started,
+		FinishedAt: finished,
+	}
+
+	if got := w.termination(); got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	if !want.OOMKilled() || !want.Evicted() || want.DeadlineExceeded() {
+		t.Errorf("unexpected termination flags")
+	}
+}
+
+func TestExtractPod_Scheduling(t *testing.T) {
+	pod := &v1.Pod{
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			Conditions: []v1.PodCondition{
+				{
+					Type:    v1.PodScheduled,
+					Status:  v1.ConditionFalse,
+					Reason:  v1.PodReasonUnschedulable,
+					Message: "0/12 nodes are available: 12 Insufficient cpu.",
+				},
+			},
+		},
+	}
+
+	info := extractPod(pod)
+	if info.scheduled {
+		t.Errorf("expected the pod not to be scheduled")
+	}
+	if info.phase != "Pending" {
+		t.Errorf("unexpected pod phase: %s", info.phase)
+	}
+	if info.unschedulableMessage != "0/12 nodes are available: 12 Insufficient cpu." {
+		t.Errorf("unexpected unschedulable message: %s", info.unschedulableMessage)
+	}
+
+	pod.Status.Conditions[0] = v1.PodCondition{Type: v1.PodScheduled, Status: v1.ConditionTrue}
+
+	if info := extractPod(pod); !info.scheduled || info.unschedulableMessage != "" {
+		t.Errorf("expected the pod to be scheduled")
+	}
+
+	// pods with the node name bypass the scheduler
+	pod = &v1.Pod{Spec: v1.PodSpec{NodeName: "node"}}
+
+	if info := extractPod(pod); !info.scheduled {
+		t.Errorf("expected the pod to be scheduled")
+	}
+}
+
+func TestExtractPod_Eviction(t *testing.T) {
+	now := metav1.Now()
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &now},
+		Status: v1.PodStatus{
+			Phase:   v1.PodFailed,
+			Reason:  "Evicted",
+			Message: "The node was low on resource: memory.",
+		},
+	}
+
+	info := extractPod(pod)
+	if info.reason != "Evicted" || info.message != "The node was low on resource: memory." || !info.deleting {
+		t.Errorf("unexpected pod info: %+v", info)
+	}
+
+	want := PodEvictedError{Pod: "pod", Phase: "Failed", Reason: "Evicted", Message: "The node was low on resource: memory."}
+	if err := podFailure("pod", info); err != want {
+		t.Errorf("want %v, got %v", want, err)
+	}
+
+	if err := podFailure("pod", extractPod(&v1.Pod{Status: v1.PodStatus{Phase: v1.PodRunning}})); err != nil {
+		t.Errorf("expected no error for a running pod, got %v", err)
+	}
+}
+
+func TestExtractPod_Sidecars(t *testing.T) {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{
+				{Name: "drone-step", Image: "golang:1.17"},
+				{Name: "istio-proxy", Image: "istio/proxyv2"},
+			},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "drone-step", Image: "golang:1.17", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+				{Name: "istio-proxy", Image: "istio/proxyv2", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+			},
+		},
+	}
+
+	info := extractPod(pod)
+
+	if len(info.sidecars) != 1 || info.sidecars[0] != "istio-proxy" {
+		t.Errorf("expected the istio-proxy sidecar, got %v", info.sidecars)
+	}
+
+	if len(info.containers) != 1 || info.containers[0].id != "drone-step" {
+		t.Errorf("expected the proxy to be excluded from the step containers, got %+v", info.containers)
+	}
+}
+
+func TestExtractContainers_NativeSidecar(t *testing.T) {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "database", Image: "mysql"}},
+			Containers:     []v1.Container{{Name: "build", Image: "drone/placeholder:1"}},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			InitContainerStatuses: []v1.ContainerStatus{
+				{Name: "database", Image: "mysql", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+			},
+			ContainerStatuses: []v1.ContainerStatus{
+				{Name: "build", Image: "drone/placeholder:1", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "PodInitializing"}}},
+			},
+		},
+	}
+
+	containers := extractContainers(pod)
+	if len(containers) != 2 {
+		t.Fatalf("expected the init and the regular container, got %+v", containers)
+	}
+
+	if c := containers[0]; c.id != "database" || c.state != stateRunning || c.specImage != "mysql" {
+		t.Errorf("unexpected sidecar container info: %+v", c)
+	}
+}
+
+func TestExtractContainers_NativeSidecarRestarted(t *testing.T) {
+	pod := &v1.Pod{
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "database", Image: "mysql"}},
+			Containers:     []v1.Container{{Name: "build", Image: "golang"}},
+		},
+		Status: v1.PodStatus{
+			Phase: v1.PodRunning,
+			InitContainerStatuses: []v1.ContainerStatus{
+				{
+					Name:         "database",
+					Image:        "mysql",
+					RestartCount: 1,
+					State:        v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+					LastTerminationState: v1.ContainerState{
+						Terminated: &v1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"},
+					},
+				},
+			},
+			ContainerStatuses: []v1.ContainerStatus{
+				{
+					Name:                 "build",
+					Image:                "golang",
+					RestartCount:         1,
+					State:                v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+					LastTerminationState: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 0}},
+				},
+			},
+		},
+	}
+
+	containers := extractContainers(pod)
+	if len(containers) != 2 {
+		t.Fatalf("expected the init and the regular container, got %+v", containers)
+	}
+
+	// the restarted sidecar is reported with its first termination.
+	if c := containers[0]; c.state != stateTerminated || c.exitCode != 1 || c.reason != "Error" {
+		t.Errorf("expected the restarted sidecar to be terminated, got %+v", c)
+	}
+
+	// the regular containers are reported with their current state.
+	if c := containers[1]; c.state != stateRunning {
+		t.Errorf("expected the regular container to be running, got %+v", c)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package podwatcher
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// waitDuration is the time the engine waited for a pod or a container event.
+	waitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "drone_podwatcher_wait_duration_seconds",
+		Help:    "Time spent waiting for a pod or a container event.",
+		Buckets: []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+	}, []string{"event"})
+
+	// errorsTotal is the number of errors returned by the wait functions, partitioned by the error type.
+	errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "drone_podwatcher_errors_total",
+		Help: "Number of pod watcher errors, partitioned by the error type.",
+	}, []string{"type"})
+)
+
+// CountError increments the error counter of the error's type.
+// Errors that are not defined by this package are ignored.
+func CountError(err error) {
+	if t := ErrorType(err); t != "" {
+		errorsTotal.WithLabelValues(t).Inc()
+	}
+}
+
+// waitEvent returns the name of the event a wait function waits for.
+func waitEvent(containerId string, state stepState) string {
+	switch {
+	case containerId == "":
+		return "pod_deleted"
+	case state == stepStateRunning:
+		return "container_started"
+	default:
+		return "container_terminated"
+	}
+}
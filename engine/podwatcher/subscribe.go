@@ -0,0 +1,178 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package podwatcher
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StepState is the state of a step reported by StepEvent.
+type StepState string
+
+// StepState values.
+const (
+	StepWaiting  StepState = "waiting"
+	StepRunning  StepState = "running"
+	StepFinished StepState = "finished"
+	StepFailed   StepState = "failed"
+)
+
+// StepEvent is published by the PodWatcher each time the state of a step changes.
+type StepEvent struct {
+	ContainerID  string
+	Image        string
+	State        StepState
+	Reason       string
+	ExitCode     int32
+	RestartCount int32
+	Time         time.Time
+}
+
+// subscriber queues the events of a single subscription. The queue is unbounded,
+// so a slow subscriber doesn't block the PodWatcher and doesn't lose events.
+type subscriber struct {
+	ch     chan StepEvent
+	notify chan struct{}
+
+	mx     sync.Mutex
+	queue  []StepEvent
+	closed bool
+}
+
+func (s *subscriber) push(e StepEvent) {
+	s.mx.Lock()
+	s.queue = append(s.queue, e)
+	s.mx.Unlock()
+
+	s.signal()
+}
+
+func (s *subscriber) close() {
+	s.mx.Lock()
+	s.closed = true
+	s.mx.Unlock()
+
+	s.signal()
+}
+
+func (s *subscriber) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default: // the subscriber is already notified
+	}
+}
+
+// pop returns the queued events, and true if no more events will be queued.
+func (s *subscriber) pop() ([]StepEvent, bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	events := s.queue
+	s.queue = nil
+
+	return events, s.closed
+}
+
+// run forwards the queued events to the subscriber's channel until the context is done,
+// or the PodWatcher finishes and all events are delivered.
+func (s *subscriber) run(ctx context.Context) {
+	defer close(s.ch)
+
+	for {
+		events, closed := s.pop()
+		for _, e := range events {
+			select {
+			case s.ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if closed && len(events) == 0 {
+			return
+		}
+
+		if len(events) > 0 {
+			continue // more events might be queued in the meantime
+		}
+
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Subscribe returns a channel that receives an event for each change of a step's state.
+// The channel is closed when the context is done, or after the PodWatcher finishes.
+func (pw *PodWatcher) Subscribe(ctx context.Context) <-chan StepEvent {
+	s := &subscriber{
+		ch:     make(chan StepEvent),
+		notify: make(chan struct{}, 1),
+	}
+
+	pw.subMx.Lock()
+	if pw.subClosed {
+		s.closed = true
+	} else {
+		if pw.subscribers == nil {
+			pw.subscribers = make(map[*subscriber]struct{})
+		}
+		pw.subscribers[s] = struct{}{}
+	}
+	pw.subMx.Unlock()
+
+	go func() {
+		s.run(ctx)
+
+		pw.subMx.Lock()
+		delete(pw.subscribers, s)
+		pw.subMx.Unlock()
+	}()
+
+	return s.ch
+}
+
+// publish sends the current state of the container to all subscribers.
+func (pw *PodWatcher) publish(c *containerWatchInfo, restartCount int32) {
+	state := StepWaiting
+	switch c.stepState {
+	case stepStateRunning:
+		state = StepRunning
+	case stepStateFinished:
+		state = StepFinished
+	case stepStatePlaceholderFailed, stepStateFailed:
+		state = StepFailed
+	}
+
+	e := StepEvent{
+		ContainerID:  c.id,
+		Image:        c.image,
+		State:        state,
+		Reason:       c.reason,
+		ExitCode:     c.exitCode,
+		RestartCount: restartCount,
+		Time:         time.Now(),
+	}
+
+	pw.subMx.Lock()
+	for s := range pw.subscribers {
+		s.push(e)
+	}
+	pw.subMx.Unlock()
+}
+
+// closeSubscribers is called when the PodWatcher finishes, no more events are published after that.
+func (pw *PodWatcher) closeSubscribers() {
+	pw.subMx.Lock()
+	pw.subClosed = true
+	for s := range pw.subscribers {
+		s.close()
+	}
+	pw.subMx.Unlock()
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/drone-runners/drone-runner-kube/internal/docker/image"
 
 	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
 )
 
 // PodWatcher is used to monitor status of a Kubernetes pod and containers inside of it.
@@ -24,6 +25,22 @@ type PodWatcher struct {
 	// containerWatchInfo holds info about all containers in the pod.
 	containerMap map[string]*containerWatchInfo
 
+	// podPhase holds the last known phase of the pod.
+	podPhase string
+
+	// podScheduled is set to true when the pod gets assigned to a node.
+	podScheduled bool
+
+	// unschedulableMessage holds the last reason, provided by the scheduler, why the pod can't be scheduled.
+	unschedulableMessage string
+
+	// podFailure is set when the pod fails, is evicted or deleted. No container of the pod will start after that.
+	podFailure error
+
+	// sidecars holds the names of the service mesh proxies injected into the pod.
+	sidecarMx sync.Mutex
+	sidecars  []string
+
 	// state represents PodWatcher state and can be: "init", "started" or "done".
 	state watcherState
 
@@ -40,8 +57,17 @@ type PodWatcher struct {
 	// clientCh is a channel through which new wait clients are added.
 	clientCh chan *waitClient
 
+	// expireCh is a channel through which wait clients, that waited too long, are removed.
+	expireCh chan *waitClient
+
 	// clientList is an array of wait clients that are currently waiting for an event.
 	clientList []*waitClient
+
+	// subscribers receive the step state changes. They are protected by the subMx mutex,
+	// because they are added by the Subscribe method and removed when a subscription ends.
+	subMx       sync.Mutex
+	subscribers map[*subscriber]struct{}
+	subClosed   bool
 }
 
 type watcherState byte
@@ -64,6 +90,7 @@ func (pw *PodWatcher) Start(ctx context.Context, cw ContainerWatcher) {
 	pw.stop = make(chan struct{}) // stop channel, close the channel to terminate the PodWatcher
 	pw.containerRegCh = make(chan containerRegInfo)
 	pw.clientCh = make(chan *waitClient) // a channel for accepting new wait clients
+	pw.expireCh = make(chan *waitClient)
 
 	errDone := make(chan error)
 
@@ -71,14 +98,14 @@ func (pw *PodWatcher) Start(ctx context.Context, cw ContainerWatcher) {
 	wg.Add(3)
 
 	// Listening container events related to the pod.
-	chEvents := make(chan []containerInfo)
+	chEvents := make(chan podInfo)
 	go func() {
 		defer wg.Done()
 		errDone <- cw.Watch(ctx, chEvents)
 	}()
 
 	// Periodic scanning of containers. This should help in case an event was missed.
-	chPeriodic := make(chan []containerInfo)
+	chPeriodic := make(chan podInfo)
 	go func() {
 		defer wg.Done()
 		_ = cw.PeriodicCheck(ctx, chPeriodic, pw.stop)
@@ -89,6 +116,7 @@ func (pw *PodWatcher) Start(ctx context.Context, cw ContainerWatcher) {
 			close(pw.stop)
 			pw.state = stateDone
 			pw.notifyClientsPodTerminated(pw.errDone)
+			pw.closeSubscribers()
 
 			wg.Done()
 		}()
@@ -102,11 +130,11 @@ func (pw *PodWatcher) Start(ctx context.Context, cw ContainerWatcher) {
 			case pw.errDone = <-errDone:
 				return
 
-			case containers := <-chEvents:
-				pw.updateContainers(containers)
+			case pod := <-chEvents:
+				pw.updatePod(pod)
 
-			case containers := <-chPeriodic:
-				pw.updateContainers(containers)
+			case pod := <-chPeriodic:
+				pw.updatePod(pod)
 
 			case c := <-pw.containerRegCh:
 				if pw.containerMap == nil {
@@ -121,7 +149,23 @@ func (pw *PodWatcher) Start(ctx context.Context, cw ContainerWatcher) {
 					addedAt:     time.Now(),
 				}
 
+				pw.publish(pw.containerMap[c.containerId], 0)
+
+			case cl := <-pw.expireCh: // a waitClient waited too long
+				pw.expireClient(cl)
+
 			case cl := <-pw.clientCh: // a new waitClient is waiting for a container state
+				if cl.waitForScheduled {
+					if pw.podScheduled {
+						cl.resolveCh <- nil
+					} else if pw.podFailure != nil {
+						cl.resolveCh <- pw.podFailure
+					} else {
+						pw.clientList = append(pw.clientList, cl)
+					}
+					break
+				}
+
 				if cl.containerId == "" {
 					// The waitClient is not asking for a container status, but the status of the whole pod.
 					// Put the waitClient to the list of unresolved clients.
@@ -139,8 +183,13 @@ func (pw *PodWatcher) Start(ctx context.Context, cw ContainerWatcher) {
 
 				// Try to resolve the waitClient right now...
 				if !_tryResolveWaitClient(cl, c) {
-					// ... if can't, put the waitClient to the list of unresolved clients.
-					pw.clientList = append(pw.clientList, cl)
+					if pw.podFailure != nil {
+						// ... the container will never reach the state if the pod has failed...
+						cl.resolveCh <- pw.podFailure
+					} else {
+						// ... if can't, put the waitClient to the list of unresolved clients.
+						pw.clientList = append(pw.clientList, cl)
+					}
 				}
 			}
 		}
@@ -152,6 +201,76 @@ func (pw *PodWatcher) Start(ctx context.Context, cw ContainerWatcher) {
 	}()
 }
 
+// updatePod updates the state of the pod and examines all containers in the pod.
+func (pw *PodWatcher) updatePod(pod podInfo) {
+	if pw.podPhase != pod.phase {
+		logrus.
+			WithField("pod", pw.podName).
+			WithField("phase", pod.phase).
+			Debug("PodWatcher: Pod phase changed")
+		pw.podPhase = pod.phase
+	}
+
+	if !pw.podScheduled {
+		if pod.unschedulableMessage != "" && pod.unschedulableMessage != pw.unschedulableMessage {
+			logrus.
+				WithField("pod", pw.podName).
+				WithField("message", pod.unschedulableMessage).
+				Debug("PodWatcher: Pod unschedulable")
+		}
+		pw.unschedulableMessage = pod.unschedulableMessage
+
+		if pod.scheduled {
+			pw.podScheduled = true
+			pw.notifyClientsPodScheduled()
+		}
+	}
+
+	if len(pod.sidecars) > 0 {
+		pw.sidecarMx.Lock()
+		if len(pw.sidecars) == 0 {
+			logrus.
+				WithField("pod", pw.podName).
+				WithField("sidecars", pod.sidecars).
+				Debug("PodWatcher: Service mesh proxies found")
+		}
+		pw.sidecars = pod.sidecars
+		pw.sidecarMx.Unlock()
+	}
+
+	pw.updateContainers(pod.containers)
+
+	// The containers are examined first, so that the clients waiting for a container,
+	// that is terminated because of the pod failure, are resolved with the termination details.
+	if pw.podFailure == nil {
+		pw.podFailure = podFailure(pw.podName, pod)
+		if pw.podFailure != nil {
+			logrus.
+				WithError(pw.podFailure).
+				WithField("pod", pw.podName).
+				Debug("PodWatcher: Pod failed")
+		}
+	}
+
+	if pw.podFailure != nil {
+		pw.notifyClientsPodFailed(pw.podFailure)
+	}
+}
+
+// podFailure returns PodEvictedError if the pod has failed, was evicted or is being deleted.
+func podFailure(podName string, pod podInfo) error {
+	switch {
+	case pod.reason == "Evicted" || pod.reason == "Preempting" || pod.reason == "NodeLost":
+	case pod.phase == string(v1.PodFailed):
+	case pod.deleting:
+		return PodEvictedError{Pod: podName, Phase: pod.phase, Reason: "Deleted", Message: pod.message}
+	default:
+		return nil
+	}
+
+	return PodEvictedError{Pod: podName, Phase: pod.phase, Reason: pod.reason, Message: pod.message}
+}
+
 // updateContainers examines all containers in a pod and if any changes are detected it executes
 // the method notifyClientsContainerChange for each changed container.
 func (pw *PodWatcher) updateContainers(containers []containerInfo) {
@@ -175,7 +294,35 @@ func (pw *PodWatcher) updateContainers(containers []containerInfo) {
 			continue
 		}
 
-		isPlaceholder := image.Match(cs.image, c.placeholder)
+		// The image of the container can't be pulled or the container can't be created.
+		// Kubernetes would keep retrying, but the step fails right away with the reason.
+		if cs.state == stateWaiting && isImageErrorReason(cs.reason) {
+			logrus.
+				WithField("pod", pw.podName).
+				WithField("container", c.id).
+				WithField("message", cs.message).
+				WithFields(cs.stateToMap()).
+				Debug("PodWatcher: Container image failed.")
+
+			c.stepState = stepStateFailed
+			c.exitCode = 0
+			c.reason = cs.reason
+			c.message = cs.message
+			if cs.specImage != "" {
+				c.failedImage = cs.specImage
+			} else {
+				c.failedImage = c.image
+			}
+
+			pw.publish(c, cs.restartCount)
+			pw.notifyClients(c)
+			continue
+		}
+
+		// The container status reports the image of the container that is actually running,
+		// or was the last to run. It's the placeholder image until the step's image is pulled
+		// and the container is restarted with it.
+		isPlaceholder := image.Match(cs.image, c.placeholder) && !isStepImage(cs, c.image)
 
 		// A running container with placeholder image (that we track, so present in pw.containerMap)
 		// usually means that Kubernetes is downloading the real step image in background.
@@ -183,48 +330,15 @@ func (pw *PodWatcher) updateContainers(containers []containerInfo) {
 			continue
 		}
 
-		// Sometimes, kubernetes sends an event about a terminated container with:
-		// Terminated.ExitCode=2 and Terminated.Reason="Error".
-		// Often container the current image will revert back to the placeholder image.
-		// Kubernetes is probably downloading an image for the container in the background.
-		if cs.exitCode == 2 && cs.reason == "Error" {
-			if cs.restartCount == 0 {
-				continue
-			}
-
-			recoveryDuration := time.Minute
-
-			if c.failedAt.IsZero() {
-				logrus.
-					WithField("pod", pw.podName).
-					WithField("container", c.id).
-					WithFields(cs.stateToMap()).
-					Trace("PodWatcher: Container failed. Trying recovery...")
-				c.failedAt = time.Now()
-			} else if time.Since(c.failedAt) < recoveryDuration {
-				logrus.
-					WithField("pod", pw.podName).
-					WithField("container", c.id).
-					WithFields(cs.stateToMap()).
-					Trace("PodWatcher: Container failed. Waiting to recover...")
-			} else {
-				logrus.
-					WithField("pod", pw.podName).
-					WithField("container", c.id).
-					WithFields(cs.stateToMap()).
-					Debug("PodWatcher: Container failed.")
-
-				c.stepState = stepStateFailed
-				c.exitCode = cs.exitCode
-				c.reason = cs.reason
-
-				pw.notifyClients(c)
-			}
-
+		// The placeholder is terminated by Kubernetes when the container's image is replaced
+		// with the step's image, and the container is restarted after the image is pulled.
+		if isPlaceholder && cs.state == stateTerminated && image.Match(cs.specImage, c.image) {
+			logrus.
+				WithField("pod", pw.podName).
+				WithField("container", c.id).
+				WithFields(cs.stateToMap()).
+				Trace("PodWatcher: Container placeholder replaced. Waiting for the step image...")
 			continue
-
-		} else {
-			c.failedAt = time.Time{}
 		}
 
 		switch cs.state {
@@ -248,7 +362,13 @@ func (pw *PodWatcher) updateContainers(containers []containerInfo) {
 			}
 			c.exitCode = cs.exitCode
 			c.reason = cs.reason
+			c.signal = cs.signal
+			c.message = cs.message
+			c.podReason = cs.podReason
+			c.startedAt = cs.startedAt
+			c.finishedAt = cs.finishedAt
 
+			pw.publish(c, cs.restartCount)
 			pw.notifyClients(c)
 
 		case stateWaiting, stateRunning:
@@ -274,6 +394,7 @@ func (pw *PodWatcher) updateContainers(containers []containerInfo) {
 				WithFields(cs.stateToMap()).
 				Debug("PodWatcher: Container state changed")
 
+			pw.publish(c, cs.restartCount)
 			pw.notifyClients(c)
 		}
 	}
@@ -285,19 +406,14 @@ func (pw *PodWatcher) notifyClients(c *containerWatchInfo) {
 	case stepStateRunning, stepStateFinished:
 		pw.notifyClientsContainerChange(c)
 	case stepStatePlaceholderFailed, stepStateFailed:
-		pw.notifyClientsError(c, FailedContainerError{
-			container: c.id,
-			exitCode:  c.exitCode,
-			reason:    c.reason,
-			image:     c.image,
-		})
+		pw.notifyClientsError(c, c.failure())
 	}
 }
 
-// notifyClientsError resolves wait clients with an error.
+// notifyClientsError resolves wait clients with an error. An image that can't be pulled fails
+// only the step of the container, the clients of the other containers keep waiting.
 func (pw *PodWatcher) notifyClientsError(c *containerWatchInfo, err error) {
-	_, isFailed := err.(FailedContainerError)
-	isKubeError := isFailed
+	_, isKubeError := err.(FailedContainerError)
 
 	for clIdx := 0; clIdx < len(pw.clientList); {
 		cl := pw.clientList[clIdx]
@@ -342,12 +458,69 @@ func (pw *PodWatcher) notifyClientsContainerChange(c *containerWatchInfo) {
 	}
 }
 
+// notifyClientsPodFailed resolves all wait clients, except those waiting for the pod to be deleted, with the error.
+func (pw *PodWatcher) notifyClientsPodFailed(err error) {
+	for clIdx := 0; clIdx < len(pw.clientList); {
+		cl := pw.clientList[clIdx]
+
+		if cl.containerId == "" && !cl.waitForScheduled {
+			clIdx++
+			continue
+		}
+
+		cl.resolveCh <- err
+
+		// remove the waitClient from the list (order is not preserved)
+		pw.clientList[clIdx] = pw.clientList[len(pw.clientList)-1]
+		pw.clientList[len(pw.clientList)-1] = nil
+		pw.clientList = pw.clientList[:len(pw.clientList)-1]
+	}
+}
+
+// notifyClientsPodScheduled resolves all wait clients that wait for the pod to be scheduled.
+func (pw *PodWatcher) notifyClientsPodScheduled() {
+	for clIdx := 0; clIdx < len(pw.clientList); {
+		cl := pw.clientList[clIdx]
+
+		if !cl.waitForScheduled {
+			clIdx++
+			continue
+		}
+
+		cl.resolveCh <- nil
+
+		// remove the waitClient from the list (order is not preserved)
+		pw.clientList[clIdx] = pw.clientList[len(pw.clientList)-1]
+		pw.clientList[len(pw.clientList)-1] = nil
+		pw.clientList = pw.clientList[:len(pw.clientList)-1]
+	}
+}
+
+// expireClient resolves the wait client, if it's still waiting, with an error.
+// Currently, only the clients waiting for the pod to be scheduled can expire.
+func (pw *PodWatcher) expireClient(cl *waitClient) {
+	for clIdx := range pw.clientList {
+		if pw.clientList[clIdx] != cl {
+			continue
+		}
+
+		cl.resolveCh <- UnschedulableError{Pod: pw.podName, Message: pw.unschedulableMessage}
+
+		// remove the waitClient from the list (order is not preserved)
+		pw.clientList[clIdx] = pw.clientList[len(pw.clientList)-1]
+		pw.clientList[len(pw.clientList)-1] = nil
+		pw.clientList = pw.clientList[:len(pw.clientList)-1]
+
+		return
+	}
+}
+
 // notifyClientsPodTerminated resolves all wait clients
 func (pw *PodWatcher) notifyClientsPodTerminated(err error) {
 	for _, cl := range pw.clientList {
 		if err != nil {
 			cl.resolveCh <- err
-		} else if cl.containerId == "" {
+		} else if cl.containerId == "" && !cl.waitForScheduled {
 			cl.resolveCh <- nil
 		} else {
 			cl.resolveCh <- PodTerminatedError{}
@@ -369,19 +542,26 @@ func _tryResolveWaitClient(cl *waitClient, c *containerWatchInfo) bool {
 		return false
 	}
 
-	if cl.waitForState == stepStateFinished && c.exitCode != 0 {
-		// tell the waitClient that the container failed with an exit code != 0
-		cl.resolveCh <- exitCodeError(int(c.exitCode))
-	} else {
-		// tell the waitClient to proceed
-		cl.resolveCh <- nil
+	// the container has already failed, for example its image couldn't be pulled.
+	if c.stepState == stepStatePlaceholderFailed || c.stepState == stepStateFailed {
+		cl.resolveCh <- c.failure()
+		return true
+	}
+
+	if cl.waitForState == stepStateFinished {
+		// tell the waitClient how the container terminated
+		cl.termination = c.termination()
 	}
 
+	// tell the waitClient to proceed
+	cl.resolveCh <- nil
+
 	return true
 }
 
-func (pw *PodWatcher) waitForEvent(containerId string, stepState stepState) (err error) {
+func (pw *PodWatcher) waitForEvent(containerId string, stepState stepState) (termination Termination, err error) {
 	ch := make(chan error)
+	cl := &waitClient{containerId: containerId, waitForState: stepState, resolveCh: ch}
 
 	logrus.
 		WithField("pod", pw.podName).
@@ -390,6 +570,7 @@ func (pw *PodWatcher) waitForEvent(containerId string, stepState stepState) (err
 		Debug("PodWatcher: Waiting...")
 
 	defer func(t time.Time) {
+		waitDuration.WithLabelValues(waitEvent(containerId, stepState)).Observe(time.Since(t).Seconds())
 		logrus.
 			WithError(err).
 			WithField("pod", pw.podName).
@@ -399,8 +580,9 @@ func (pw *PodWatcher) waitForEvent(containerId string, stepState stepState) (err
 	}(time.Now())
 
 	select {
-	case pw.clientCh <- &waitClient{containerId: containerId, waitForState: stepState, resolveCh: ch}:
+	case pw.clientCh <- cl:
 		err = <-ch
+		termination = cl.termination
 
 	case <-pw.stop:
 		if pw.errDone != nil {
@@ -415,23 +597,62 @@ func (pw *PodWatcher) waitForEvent(containerId string, stepState stepState) (err
 
 // WaitContainerStart waits until a container in the pod starts.
 func (pw *PodWatcher) WaitContainerStart(containerId string) error {
-	return pw.waitForEvent(containerId, stepStateRunning)
+	_, err := pw.waitForEvent(containerId, stepStateRunning)
+	return err
 }
 
 // WaitContainerTerminated waits until a container in the pod is terminated.
-func (pw *PodWatcher) WaitContainerTerminated(containerId string) (int, error) {
-	err := pw.waitForEvent(containerId, stepStateFinished)
-	if code, ok := err.(exitCodeError); ok { // exit codes != 0 are masked as a exitCodeError
-		return int(code), nil
+// It returns details about the container termination, including the exit code.
+func (pw *PodWatcher) WaitContainerTerminated(containerId string) (Termination, error) {
+	return pw.waitForEvent(containerId, stepStateFinished)
+}
+
+// WaitPodScheduled waits until the pod is scheduled to a node. If the pod isn't scheduled within
+// the timeout, UnschedulableError is returned with the last reason provided by the scheduler.
+func (pw *PodWatcher) WaitPodScheduled(timeout time.Duration) (err error) {
+	// the channel is buffered because the client can be resolved while it's being expired
+	ch := make(chan error, 1)
+	cl := &waitClient{waitForScheduled: true, resolveCh: ch}
+
+	defer func(t time.Time) {
+		waitDuration.WithLabelValues("pod_scheduled").Observe(time.Since(t).Seconds())
+		logrus.
+			WithError(err).
+			WithField("pod", pw.podName).
+			Debugf("PodWatcher: Wait for pod scheduling finished. Duration=%.2fs", time.Since(t).Seconds())
+	}(time.Now())
+
+	select {
+	case pw.clientCh <- cl:
+	case <-pw.stop:
+		if pw.errDone != nil {
+			return pw.errDone
+		}
+		return PodTerminatedError{}
 	}
 
-	return 0, err
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-ch:
+		return
+	case <-timer.C:
+	}
+
+	select {
+	case pw.expireCh <- cl:
+	case <-pw.stop: // the client is resolved when the PodWatcher finishes
+	}
+
+	return <-ch
 }
 
 // WaitPodDeleted waits until the pod is deleted.
 func (pw *PodWatcher) WaitPodDeleted() (err error) {
 	// note: the state used below is unimportant, it's used only for logging
-	return pw.waitForEvent("", stepStateFinished)
+	_, err = pw.waitForEvent("", stepStateFinished)
+	return
 }
 
 // AddContainer registers a container for state tracking.
@@ -446,6 +667,14 @@ func (pw *PodWatcher) AddContainer(id, placeholder, image string) error {
 	}
 }
 
+// Sidecars returns the names of the service mesh proxy containers injected into the pod.
+func (pw *PodWatcher) Sidecars() []string {
+	pw.sidecarMx.Lock()
+	defer pw.sidecarMx.Unlock()
+
+	return pw.sidecars
+}
+
 func (pw *PodWatcher) Name() string {
 	return pw.podName
 }
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
 )
 
 const placeholder = "placeholder"
@@ -48,7 +49,7 @@ func makeContainerWatcher(containers []string) *testContainerWatcher {
 
 func (w *testContainerWatcher) Name() string { return "Test" }
 
-func (w *testContainerWatcher) Watch(ctx context.Context, containers chan<- []containerInfo) error {
+func (w *testContainerWatcher) Watch(ctx context.Context, pods chan<- podInfo) error {
 	for {
 		select {
 		case <-ctx.Done():
@@ -64,12 +65,12 @@ func (w *testContainerWatcher) Watch(ctx context.Context, containers chan<- []co
 					cc[i] = c
 				}
 			}
-			containers <- cc
+			pods <- podInfo{scheduled: true, containers: cc}
 		}
 	}
 }
 
-func (w *testContainerWatcher) PeriodicCheck(ctx context.Context, containers chan<- []containerInfo, stop <-chan struct{}) error {
+func (w *testContainerWatcher) PeriodicCheck(ctx context.Context, pods chan<- podInfo, stop <-chan struct{}) error {
 	return nil
 }
 
@@ -318,7 +319,8 @@ func TestPodWatcher(t *testing.T) {
 						wg.Add(1)
 						go func(testName, containerId string, stepIdx int, expected error, expectedExitCode int) {
 							defer wg.Done()
-							exitCode, err := pw.WaitContainerTerminated(containerId)
+							termination, err := pw.WaitContainerTerminated(containerId)
+							exitCode := termination.ExitCode
 							if err != nil && expected == nil {
 								t.Errorf("test %q, step=%d failed: expected no error but got %v", testName, stepIdx, err)
 							} else if expected != nil && (err == nil || reflect.TypeOf(err) != reflect.TypeOf(expected)) {
@@ -354,3 +356,341 @@ func TestPodWatcher(t *testing.T) {
 		}()
 	}
 }
+
+type testPodWatcher struct {
+	pods chan podInfo
+}
+
+func (w *testPodWatcher) Name() string { return "Test" }
+
+func (w *testPodWatcher) Watch(ctx context.Context, pods chan<- podInfo) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case pod := <-w.pods:
+			pods <- pod
+		}
+	}
+}
+
+func (w *testPodWatcher) PeriodicCheck(ctx context.Context, pods chan<- podInfo, stop <-chan struct{}) error {
+	return nil
+}
+
+func TestPodWatcher_WaitPodScheduled(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	cw := &testPodWatcher{pods: make(chan podInfo)}
+
+	pw := &PodWatcher{}
+	pw.Start(ctx, cw)
+
+	cw.pods <- podInfo{phase: "Pending", unschedulableMessage: "0/3 nodes are available: 3 Insufficient cpu."}
+
+	err := pw.WaitPodScheduled(50 * time.Millisecond)
+	if e, ok := err.(UnschedulableError); !ok {
+		t.Errorf("expected UnschedulableError, got %v", err)
+	} else if e.Message != "0/3 nodes are available: 3 Insufficient cpu." {
+		t.Errorf("unexpected scheduler message: %s", e.Message)
+	}
+
+	chErr := make(chan error)
+	go func() {
+		chErr <- pw.WaitPodScheduled(time.Second)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cw.pods <- podInfo{phase: "Pending", scheduled: true}
+
+	if err := <-chErr; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	// already scheduled
+	if err := pw.WaitPodScheduled(time.Millisecond); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	cancelFunc()
+
+	if err := pw.WaitPodScheduled(time.Second); err != context.Canceled {
+		t.Errorf("expected context canceled error, got %v", err)
+	}
+}
+
+func TestPodWatcher_ImageSwap(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	cw := &testPodWatcher{pods: make(chan podInfo)}
+
+	pw := &PodWatcher{}
+	pw.Start(ctx, cw)
+
+	_ = pw.AddContainer("A", placeholder, "golang:1.17")
+	_ = pw.AddContainer("B", placeholder, "node:missing")
+
+	chErrA := make(chan error, 1)
+	go func() {
+		chErrA <- pw.WaitContainerStart("A")
+	}()
+
+	chErrB := make(chan error, 1)
+	go func() {
+		chErrB <- pw.WaitContainerStart("B")
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	// the placeholder of the container A is terminated, because its image is replaced, it must not fail the step.
+	cw.pods <- podInfo{scheduled: true, containers: []containerInfo{
+		{id: "A", state: stateTerminated, image: placeholder, specImage: "golang:1.17", exitCode: 2, reason: "Error", restartCount: 1},
+		{id: "B", state: stateRunning, image: placeholder, specImage: placeholder},
+	}}
+
+	// the step image is running, reported only by the image ID.
+	cw.pods <- podInfo{scheduled: true, containers: []containerInfo{
+		{id: "A", state: stateRunning, image: placeholder, imageID: "docker-pullable://golang@sha256:1e5d2f5a0ca8e4e0dbbda6fb8b5e7b7cfb1db2a4f6a6e0eb7e3d4b33c5c6f8a9", specImage: "golang:1.17", restartCount: 1},
+		{id: "B", state: stateRunning, image: placeholder, specImage: placeholder},
+	}}
+
+	if err := <-chErrA; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	cw.pods <- podInfo{scheduled: true, containers: []containerInfo{
+		{id: "A", state: stateRunning, image: "golang:1.17", specImage: "golang:1.17", restartCount: 1},
+		{id: "B", state: stateWaiting, image: "node:missing", specImage: "node:missing", reason: "ErrImagePull", message: "manifest unknown"},
+	}}
+
+	want := ImagePullError{Container: "B", Image: "node:missing", Reason: "ErrImagePull", Message: "manifest unknown"}
+	if err := <-chErrB; err != want {
+		t.Errorf("expected %v, got %v", want, err)
+	}
+
+	// the error is returned to the clients that wait for the failed container later.
+	if _, err := pw.WaitContainerTerminated("B"); err != want {
+		t.Errorf("expected %v, got %v", want, err)
+	}
+}
+
+func TestPodWatcher_PodEvicted(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	cw := &testPodWatcher{pods: make(chan podInfo)}
+
+	pw := &PodWatcher{}
+	pw.Start(ctx, cw)
+
+	_ = pw.AddContainer("A", placeholder, "golang")
+	_ = pw.AddContainer("B", placeholder, "node")
+
+	chErrStart := make(chan error, 1)
+	go func() {
+		chErrStart <- pw.WaitContainerStart("A")
+	}()
+
+	chErrDeleted := make(chan error, 1)
+	go func() {
+		chErrDeleted <- pw.WaitPodDeleted()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	cw.pods <- podInfo{phase: "Failed", reason: "Evicted", message: "The node was low on resource: memory.", scheduled: true}
+
+	want := PodEvictedError{Pod: "Test", Phase: "Failed", Reason: "Evicted", Message: "The node was low on resource: memory."}
+
+	if err := <-chErrStart; err != want {
+		t.Errorf("expected %v, got %v", want, err)
+	}
+
+	// the clients that start waiting after the eviction are resolved right away.
+	if _, err := pw.WaitContainerTerminated("B"); err != want {
+		t.Errorf("expected %v, got %v", want, err)
+	}
+
+	// the client waiting for the pod deletion is not resolved by the eviction.
+	select {
+	case err := <-chErrDeleted:
+		t.Errorf("expected the client to wait for the pod deletion, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancelFunc()
+}
+
+func TestPodWatcher_Subscribe(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	cw := &testPodWatcher{pods: make(chan podInfo)}
+
+	pw := &PodWatcher{}
+	pw.Start(ctx, cw)
+
+	events := pw.Subscribe(context.Background())
+
+	_ = pw.AddContainer("A", placeholder, "golang")
+
+	cw.pods <- podInfo{scheduled: true, containers: []containerInfo{
+		{id: "A", state: stateRunning, image: "golang", restartCount: 1},
+	}}
+	cw.pods <- podInfo{scheduled: true, containers: []containerInfo{
+		{id: "A", state: stateTerminated, image: "golang", exitCode: 1, reason: "Error", restartCount: 1},
+	}}
+
+	var got []StepEvent
+	for e := range events {
+		if e.ContainerID != "A" || e.Image != "golang" || e.Time.IsZero() {
+			t.Errorf("unexpected event: %+v", e)
+		}
+		e.Time = time.Time{}
+		got = append(got, e)
+
+		// the subscription ends when the PodWatcher finishes.
+		if e.State == StepFinished {
+			cancelFunc()
+		}
+	}
+
+	want := []StepEvent{
+		{ContainerID: "A", Image: "golang", State: StepWaiting},
+		{ContainerID: "A", Image: "golang", State: StepRunning, RestartCount: 1},
+		{ContainerID: "A", Image: "golang", State: StepFinished, Reason: "Error", ExitCode: 1, RestartCount: 1},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want events %+v, got %+v", want, got)
+	}
+
+	// subscribing to a finished PodWatcher returns a closed channel.
+	if _, ok := <-pw.Subscribe(context.Background()); ok {
+		t.Errorf("expected the channel to be closed")
+	}
+}
+
+func TestPodWatcher_ImagePullErrorOtherContainers(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	cw := &testPodWatcher{pods: make(chan podInfo)}
+
+	pw := &PodWatcher{}
+	pw.Start(ctx, cw)
+
+	_ = pw.AddContainer("A", placeholder, "golang")
+	_ = pw.AddContainer("B", placeholder, "node:missing")
+
+	chErrA := make(chan error, 1)
+	go func() {
+		_, err := pw.WaitContainerTerminated("A")
+		chErrA <- err
+	}()
+
+	chErrB := make(chan error, 1)
+	go func() {
+		chErrB <- pw.WaitContainerStart("B")
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	cw.pods <- podInfo{scheduled: true, containers: []containerInfo{
+		{id: "A", state: stateRunning, image: "golang", specImage: "golang"},
+		{id: "B", state: stateWaiting, image: "node:missing", specImage: "node:missing", reason: "ErrImagePull", message: "manifest unknown"},
+	}}
+
+	want := ImagePullError{Container: "B", Image: "node:missing", Reason: "ErrImagePull", Message: "manifest unknown"}
+	if err := <-chErrB; err != want {
+		t.Errorf("expected %v, got %v", want, err)
+	}
+
+	// the step running in the container A is not affected by the failed image of the container B.
+	select {
+	case err := <-chErrA:
+		t.Fatalf("expected the container A to be still running, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cw.pods <- podInfo{scheduled: true, containers: []containerInfo{
+		{id: "A", state: stateTerminated, image: "golang", specImage: "golang", exitCode: 0},
+		{id: "B", state: stateWaiting, image: "node:missing", specImage: "node:missing", reason: "ErrImagePull", message: "manifest unknown"},
+	}}
+
+	if err := <-chErrA; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestPodWatcher_NativeSidecarExited(t *testing.T) {
+	logrus.SetLevel(logrus.PanicLevel)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	cw := &testPodWatcher{pods: make(chan podInfo)}
+
+	pw := &PodWatcher{}
+	pw.Start(ctx, cw)
+
+	_ = pw.AddContainer("database", "", "mysql")
+
+	chTermination := make(chan Termination, 1)
+	chErr := make(chan error, 1)
+	go func() {
+		termination, err := pw.WaitContainerTerminated("database")
+		chTermination <- termination
+		chErr <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	status := func(restartCount int32, state, lastState v1.ContainerState) *v1.Pod {
+		return &v1.Pod{
+			Spec: v1.PodSpec{InitContainers: []v1.Container{{Name: "database", Image: "mysql"}}},
+			Status: v1.PodStatus{
+				Phase: v1.PodRunning,
+				InitContainerStatuses: []v1.ContainerStatus{{
+					Name:                 "database",
+					Image:                "mysql",
+					RestartCount:         restartCount,
+					State:                state,
+					LastTerminationState: lastState,
+				}},
+			},
+		}
+	}
+
+	running := v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+	crashed := v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 137, Reason: "Error"}}
+
+	cw.pods <- podInfo{scheduled: true, containers: extractContainers(status(0, running, v1.ContainerState{}))}
+
+	// kubernetes restarts the sidecar right away, the watcher never sees it terminated.
+	cw.pods <- podInfo{scheduled: true, containers: extractContainers(status(1, running, crashed))}
+
+	select {
+	case err := <-chErr:
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the restarted sidecar to be reported as terminated")
+	}
+
+	if termination := <-chTermination; termination.ExitCode != 137 || termination.Reason != "Error" {
+		t.Errorf("unexpected termination: %+v", termination)
+	}
+}
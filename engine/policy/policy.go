@@ -20,6 +20,11 @@ type (
 		NodeSelector   map[string]string `yaml:"node_selector"`
 		ServiceAccount string            `yaml:"service_account"`
 		Tolerations    []Toleration
+		Cluster        string
+
+		// Affinity and TopologySpreadConstraints override the pipeline's.
+		Affinity                  *engine.Affinity
+		TopologySpreadConstraints []engine.TopologySpreadConstraint `yaml:"topology_spread_constraints"`
 	}
 
 	// Metadata defines resource metadata.
@@ -120,4 +125,19 @@ func (p *Policy) Apply(spec *engine.Spec) {
 		}
 		spec.PodSpec.Tolerations = dst
 	}
+
+	// apply (and override) the default affinity.
+	if v := p.Affinity; v != nil {
+		spec.PodSpec.Affinity = v
+	}
+
+	// apply (and override) the default topology spread constraints.
+	if v := p.TopologySpreadConstraints; len(v) != 0 {
+		spec.PodSpec.TopologySpreadConstraints = v
+	}
+
+	// apply (and override) the cluster.
+	if v := p.Cluster; v != "" {
+		spec.Cluster = v
+	}
 }
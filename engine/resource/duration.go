@@ -0,0 +1,37 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import "time"
+
+// Duration stores a human-readable duration (eg. "90s",
+// "1h30m"). A plain number is interpreted as minutes, the
+// same unit as the repository timeout.
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml unmarshalling.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var intType int64
+	if err := unmarshal(&intType); err == nil {
+		*d = Duration(time.Duration(intType) * time.Minute)
+		return nil
+	}
+
+	var stringType string
+	if err := unmarshal(&stringType); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(stringType)
+	if err == nil {
+		*d = Duration(duration)
+	}
+	return err
+}
+
+// String returns a human-readable duration (eg. "1h30m0s").
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
@@ -0,0 +1,44 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package resource
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildkite/yaml"
+)
+
+func TestDuration(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want time.Duration
+		err  bool
+	}{
+		{yaml: "timeout: 10", want: 10 * time.Minute},
+		{yaml: "timeout: 90s", want: 90 * time.Second},
+		{yaml: "timeout: 1h30m", want: 90 * time.Minute},
+		{yaml: "timeout: forever", err: true},
+	}
+	for _, test := range tests {
+		out := struct {
+			Timeout Duration `yaml:"timeout"`
+		}{}
+		err := yaml.Unmarshal([]byte(test.yaml), &out)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expect error parsing %q", test.yaml)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", test.yaml, err)
+			continue
+		}
+		if got := time.Duration(out.Timeout); got != test.want {
+			t.Errorf("Want duration %s, got %s", test.want, got)
+		}
+	}
+}
@@ -35,6 +35,7 @@ type Pipeline struct {
 	Node        map[string]string    `json:"node,omitempty"`
 	Platform    manifest.Platform    `json:"platform,omitempty"`
 	Trigger     manifest.Conditions  `json:"conditions,omitempty"`
+	Cluster     string               `json:"cluster,omitempty"`
 
 	Resources   Resources         `json:"resources,omitempty"`
 	Environment map[string]string `json:"environment,omitempty"`
@@ -51,6 +52,10 @@ type Pipeline struct {
 	Tolerations        []Toleration      `json:"tolerations,omitempty"`
 	DnsConfig          DnsConfig         `json:"dns_config,omitempty" yaml:"dns_config"`
 	HostAliases        []HostAlias       `json:"host_aliases,omitempty" yaml:"host_aliases"`
+	SecretsAsFiles     bool              `json:"secrets_as_files,omitempty" yaml:"secrets_as_files"`
+
+	Affinity                  *Affinity                  `json:"affinity,omitempty"`
+	TopologySpreadConstraints []TopologySpreadConstraint `json:"topology_spread_constraints,omitempty" yaml:"topology_spread_constraints"`
 }
 
 // GetVersion returns the resource version.
@@ -125,27 +130,96 @@ type (
 		Value             string `json:"value,omitempty"`
 	}
 
+	// Affinity defines Kubernetes pod affinity, the required and
+	// preferred rules are the requiredDuringSchedulingIgnoredDuringExecution
+	// and the preferredDuringSchedulingIgnoredDuringExecution rules.
+	Affinity struct {
+		NodeAffinity    *NodeAffinity `json:"node_affinity,omitempty" yaml:"node_affinity"`
+		PodAffinity     *PodAffinity  `json:"pod_affinity,omitempty" yaml:"pod_affinity"`
+		PodAntiAffinity *PodAffinity  `json:"pod_anti_affinity,omitempty" yaml:"pod_anti_affinity"`
+	}
+
+	// NodeAffinity defines Kubernetes node affinity rules
+	NodeAffinity struct {
+		Required  []NodeSelectorTerm        `json:"required,omitempty"`
+		Preferred []PreferredSchedulingTerm `json:"preferred,omitempty"`
+	}
+
+	// NodeSelectorTerm defines Kubernetes node selector term
+	NodeSelectorTerm struct {
+		MatchExpressions []SelectorRequirement `json:"match_expressions,omitempty" yaml:"match_expressions"`
+		MatchFields      []SelectorRequirement `json:"match_fields,omitempty" yaml:"match_fields"`
+	}
+
+	// PreferredSchedulingTerm defines Kubernetes weighted node selector term
+	PreferredSchedulingTerm struct {
+		Weight     int32            `json:"weight,omitempty"`
+		Preference NodeSelectorTerm `json:"preference,omitempty"`
+	}
+
+	// PodAffinity defines Kubernetes pod affinity or anti-affinity rules
+	PodAffinity struct {
+		Required  []PodAffinityTerm         `json:"required,omitempty"`
+		Preferred []WeightedPodAffinityTerm `json:"preferred,omitempty"`
+	}
+
+	// PodAffinityTerm defines Kubernetes pod affinity term
+	PodAffinityTerm struct {
+		LabelSelector *LabelSelector `json:"label_selector,omitempty" yaml:"label_selector"`
+		Namespaces    []string       `json:"namespaces,omitempty"`
+		TopologyKey   string         `json:"topology_key,omitempty" yaml:"topology_key"`
+	}
+
+	// WeightedPodAffinityTerm defines Kubernetes weighted pod affinity term
+	WeightedPodAffinityTerm struct {
+		Weight          int32           `json:"weight,omitempty"`
+		PodAffinityTerm PodAffinityTerm `json:"pod_affinity_term,omitempty" yaml:"pod_affinity_term"`
+	}
+
+	// TopologySpreadConstraint defines Kubernetes pod topology spread constraint
+	TopologySpreadConstraint struct {
+		MaxSkew           int32          `json:"max_skew,omitempty" yaml:"max_skew"`
+		TopologyKey       string         `json:"topology_key,omitempty" yaml:"topology_key"`
+		WhenUnsatisfiable string         `json:"when_unsatisfiable,omitempty" yaml:"when_unsatisfiable"`
+		LabelSelector     *LabelSelector `json:"label_selector,omitempty" yaml:"label_selector"`
+	}
+
+	// LabelSelector defines Kubernetes label selector
+	LabelSelector struct {
+		MatchLabels      map[string]string     `json:"match_labels,omitempty" yaml:"match_labels"`
+		MatchExpressions []SelectorRequirement `json:"match_expressions,omitempty" yaml:"match_expressions"`
+	}
+
+	// SelectorRequirement defines Kubernetes node or label selector requirement
+	SelectorRequirement struct {
+		Key      string   `json:"key,omitempty"`
+		Operator string   `json:"operator,omitempty"`
+		Values   []string `json:"values,omitempty"`
+	}
+
 	// Step defines a Pipeline step.
 	Step struct {
-		Command     []string                       `json:"command,omitempty"`
-		Commands    []string                       `json:"commands,omitempty"`
-		Detach      bool                           `json:"detach,omitempty"`
-		DependsOn   []string                       `json:"depends_on,omitempty" yaml:"depends_on"`
-		Entrypoint  []string                       `json:"entrypoint,omitempty"`
-		Environment map[string]*manifest.Variable  `json:"environment,omitempty"`
-		Failure     string                         `json:"failure,omitempty"`
-		Image       string                         `json:"image,omitempty"`
-		Name        string                         `json:"name,omitempty"`
-		Privileged  bool                           `json:"privileged,omitempty"`
-		Pull        string                         `json:"pull,omitempty"`
-		Resources   Resources                      `json:"resource,omitempty"`
-		Settings    map[string]*manifest.Parameter `json:"settings,omitempty"`
-		Shell       string                         `json:"shell,omitempty"`
-		User        *int64                         `json:"user,omitempty"`
-		Group       *int64                         `json:"group,omitempty"`
-		Volumes     []*VolumeMount                 `json:"volumes,omitempty"`
-		When        manifest.Conditions            `json:"when,omitempty"`
-		WorkingDir  string                         `json:"working_dir,omitempty" yaml:"working_dir"`
+		Command        []string                       `json:"command,omitempty"`
+		Commands       []string                       `json:"commands,omitempty"`
+		Detach         bool                           `json:"detach,omitempty"`
+		DependsOn      []string                       `json:"depends_on,omitempty" yaml:"depends_on"`
+		Entrypoint     []string                       `json:"entrypoint,omitempty"`
+		Environment    map[string]*manifest.Variable  `json:"environment,omitempty"`
+		Failure        string                         `json:"failure,omitempty"`
+		Image          string                         `json:"image,omitempty"`
+		Name           string                         `json:"name,omitempty"`
+		Privileged     bool                           `json:"privileged,omitempty"`
+		Pull           string                         `json:"pull,omitempty"`
+		Resources      Resources                      `json:"resource,omitempty"`
+		SecretsAsFiles bool                           `json:"secrets_as_files,omitempty" yaml:"secrets_as_files"`
+		Settings       map[string]*manifest.Parameter `json:"settings,omitempty"`
+		Shell          string                         `json:"shell,omitempty"`
+		Timeout        Duration                       `json:"timeout,omitempty"`
+		User           *int64                         `json:"user,omitempty"`
+		Group          *int64                         `json:"group,omitempty"`
+		Volumes        []*VolumeMount                 `json:"volumes,omitempty"`
+		When           manifest.Conditions            `json:"when,omitempty"`
+		WorkingDir     string                         `json:"working_dir,omitempty" yaml:"working_dir"`
 	}
 
 	// Volume that can be mounted by containers.
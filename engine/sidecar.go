@@ -0,0 +1,93 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/drone-runners/drone-runner-kube/engine/mesh"
+	"github.com/drone-runners/drone-runner-kube/engine/podwatcher"
+
+	"github.com/drone/runner-go/logger"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// shutdownSidecars asks the service mesh proxies, injected into the pod, to shut down.
+// The proxies never exit on their own, so they would keep the pod running, and could
+// hold up its deletion. The proxies accept the shutdown request only from localhost,
+// so the request is sent from inside the pod: by the proxy's own tool, executed in its
+// container, or else by a request to the shutdown endpoint from a running step container.
+func (k *Kubernetes) shutdownSidecars(spec *Spec, log logger.Logger) {
+	if k.config == nil {
+		return // commands can't be executed in the containers
+	}
+
+	w, ok := k.watchers.Load(spec.PodSpec.Name)
+	if !ok {
+		return // no step has been started
+	}
+
+	for _, name := range w.(*podwatcher.PodWatcher).Sidecars() {
+		proxy, ok := mesh.Find(name)
+		if !ok {
+			continue
+		}
+
+		log := log.WithField("sidecar", name)
+
+		if len(proxy.Command) > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+			err := k.exec(ctx, spec, name, proxy.Command)
+			cancel()
+
+			if err == nil {
+				log.Trace("Engine: Shut down service mesh proxy")
+				continue
+			}
+
+			log.WithError(err).Debug("Engine: Failed to shut down service mesh proxy from its container")
+		}
+
+		if err := k.requestShutdown(spec, proxy); err != nil {
+			log.WithError(err).Warn("Engine: Failed to shut down service mesh proxy")
+		} else {
+			log.Trace("Engine: Shut down service mesh proxy")
+		}
+	}
+}
+
+// requestShutdown requests the shutdown endpoint of the proxy from a running step container,
+// which shares the network of the proxy. The step's image must have curl or wget.
+func (k *Kubernetes) requestShutdown(spec *Spec, proxy mesh.Proxy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+
+	pod, err := k.client.CoreV1().Pods(spec.PodSpec.Namespace).Get(ctx, spec.PodSpec.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	script := fmt.Sprintf("curl -fsS -X POST %[1]s || wget -q -O /dev/null --post-data= %[1]s", proxy.ShutdownURL())
+
+	err = errors.New("no running step container")
+	for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if cs.State.Running == nil || mesh.IsProxy(cs.Name) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+		err = k.exec(ctx, spec, cs.Name, []string{"/bin/sh", "-c", script})
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
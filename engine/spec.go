@@ -5,10 +5,13 @@
 package engine
 
 import (
-	"sync"
+	"time"
 
 	"github.com/drone/runner-go/environ"
 	"github.com/drone/runner-go/pipeline/runtime"
+
+	"go.opentelemetry.io/otel/trace"
+	v1 "k8s.io/api/core/v1"
 )
 
 type (
@@ -24,22 +27,48 @@ type (
 		Secrets    map[string]*Secret `json:"secrets,omitempty"`
 		PullSecret *Secret            `json:"pull_secrets,omitempty"`
 
+		// SecretPerStep instructs the engine to create a separate
+		// kubernetes secret for each step, holding only the secrets
+		// used by the step, instead of a single secret for the pod.
+		SecretPerStep bool `json:"secret_per_step,omitempty"`
+
 		// Resources hold resource limit for each container and
 		// resource request amount for the whole pod.
 		// This must be present here so that a policy can override the values.
 		Resources Resources
 
-		// Runtime field to gate updating of the pod that this pipeline
-		// is running on. Helps to avoid self-inflicted 409 Conflict
-		// responses from the kubernetes api server.
-		podUpdateMutex sync.Mutex
-
 		// Namespace is an optional namespace that should be
 		// created before the pipeline starts and executed after
 		// the pipeline completes. WARNING this field should only
 		// be set if you want custom per-pipeline namespaces.
 		Namespace string `json:"namespace,omitempty"`
 
+		// Cluster is the name of the Kubernetes cluster the pipeline
+		// runs in, if the runner dispatches pipelines to multiple clusters.
+		// If empty, the cluster is selected by the platform architecture.
+		Cluster string `json:"cluster,omitempty"`
+
+		// Debug is set if the build runs in debug mode. The pod of a failed step
+		// is kept alive, and a debug container is added to it.
+		Debug *Debug `json:"debug,omitempty"`
+
+		// SpanContext identifies the trace span of the stage. The engine's Setup and
+		// Destroy methods are not given the stage's context, so their spans are linked
+		// to the stage's trace with it.
+		SpanContext trace.SpanContext `json:"-"`
+
+		// workspaceClaim is the name of the claim that holds the workspace and the
+		// temporary volumes of a step pod run by the PodPerStep engine.
+		workspaceClaim string
+
+		// unownedSecrets holds the secrets of the pipeline whose owner couldn't be set
+		// by the engine's Setup method. They are deleted by the Destroy method.
+		unownedSecrets []*v1.Secret
+
+		// nativeSidecars is set by the engine's Setup method if the services are
+		// run as native sidecar containers, i.e. as init containers that keep running.
+		nativeSidecars bool
+
 		// stop channel is created by the engine's Setup method, and closed by the Destroy method.
 		// It's used to quickly bail out from the Run method if the pipeline is terminated or canceled.
 		stop chan struct{}
@@ -61,16 +90,24 @@ type (
 		Placeholder  string            `json:"placeholder,omitempty"`
 		Privileged   bool              `json:"privileged,omitempty"`
 		Resources    Resources         `json:"resources,omitempty"`
+		Service      bool              `json:"service,omitempty"`
 		Pull         PullPolicy        `json:"pull,omitempty"`
 		RunPolicy    runtime.RunPolicy `json:"run_policy,omitempty"`
 		Secrets      []*SecretVar      `json:"secrets,omitempty"`
 		SpecSecrets  []*Secret         `json:"spec_secrets,omitempty"`
+		Timeout      time.Duration     `json:"timeout,omitempty"`
 		User         *int64            `json:"user,omitempty"`
 		Group        *int64            `json:"group,omitempty"`
 		Volumes      []*VolumeMount    `json:"volumes,omitempty"`
 		WorkingDir   string            `json:"working_dir,omitempty"`
 	}
 
+	// Debug defines the ephemeral container used to debug a failed step.
+	Debug struct {
+		Image       string        `json:"image,omitempty"`
+		IdleTimeout time.Duration `json:"idle_timeout,omitempty"`
+	}
+
 	// Platform defines the target platform.
 	Platform struct {
 		OS      string `json:"os,omitempty"`
@@ -91,6 +128,12 @@ type (
 	SecretVar struct {
 		Name string `json:"name,omitempty"`
 		Env  string `json:"env,omitempty"`
+
+		// File instructs the engine to provide the secret
+		// as a file in the SecretsPath directory, named
+		// after the secret, instead of as an environment
+		// variable.
+		File bool `json:"file,omitempty"`
 	}
 
 	// State represents the process state.
@@ -198,6 +241,9 @@ type (
 		ServiceAccountName string            `json:"service_account_name,omitempty"`
 		HostAliases        []HostAlias       `json:"host_aliases,omitempty"`
 		DnsConfig          DnsConfig         `json:"dns_config,omitempty"`
+
+		Affinity                  *Affinity                  `json:"affinity,omitempty"`
+		TopologySpreadConstraints []TopologySpreadConstraint `json:"topology_spread_constraints,omitempty"`
 	}
 
 	// HostAlias ...
@@ -225,6 +271,74 @@ type (
 		Name  string  `json:"name,omitempty"`
 		Value *string `json:"value,omitempty"`
 	}
+
+	// Affinity defines the pod's scheduling constraints. The types of the affinity
+	// and the topology spread constraints are decoded from the policy file too.
+	Affinity struct {
+		NodeAffinity    *NodeAffinity `json:"node_affinity,omitempty"     yaml:"node_affinity"`
+		PodAffinity     *PodAffinity  `json:"pod_affinity,omitempty"      yaml:"pod_affinity"`
+		PodAntiAffinity *PodAffinity  `json:"pod_anti_affinity,omitempty" yaml:"pod_anti_affinity"`
+	}
+
+	// NodeAffinity defines the node affinity rules, the required rules
+	// must be met, the preferred rules are met if possible.
+	NodeAffinity struct {
+		Required  []NodeSelectorTerm        `json:"required,omitempty"`
+		Preferred []PreferredSchedulingTerm `json:"preferred,omitempty"`
+	}
+
+	// NodeSelectorTerm ...
+	NodeSelectorTerm struct {
+		MatchExpressions []SelectorRequirement `json:"match_expressions,omitempty" yaml:"match_expressions"`
+		MatchFields      []SelectorRequirement `json:"match_fields,omitempty"      yaml:"match_fields"`
+	}
+
+	// PreferredSchedulingTerm ...
+	PreferredSchedulingTerm struct {
+		Weight     int32            `json:"weight,omitempty"`
+		Preference NodeSelectorTerm `json:"preference,omitempty"`
+	}
+
+	// PodAffinity defines the pod affinity or anti-affinity rules, the
+	// required rules must be met, the preferred rules are met if possible.
+	PodAffinity struct {
+		Required  []PodAffinityTerm         `json:"required,omitempty"`
+		Preferred []WeightedPodAffinityTerm `json:"preferred,omitempty"`
+	}
+
+	// PodAffinityTerm ...
+	PodAffinityTerm struct {
+		LabelSelector *LabelSelector `json:"label_selector,omitempty" yaml:"label_selector"`
+		Namespaces    []string       `json:"namespaces,omitempty"`
+		TopologyKey   string         `json:"topology_key,omitempty"   yaml:"topology_key"`
+	}
+
+	// WeightedPodAffinityTerm ...
+	WeightedPodAffinityTerm struct {
+		Weight          int32           `json:"weight,omitempty"`
+		PodAffinityTerm PodAffinityTerm `json:"pod_affinity_term,omitempty" yaml:"pod_affinity_term"`
+	}
+
+	// TopologySpreadConstraint ...
+	TopologySpreadConstraint struct {
+		MaxSkew           int32          `json:"max_skew,omitempty"           yaml:"max_skew"`
+		TopologyKey       string         `json:"topology_key,omitempty"       yaml:"topology_key"`
+		WhenUnsatisfiable string         `json:"when_unsatisfiable,omitempty" yaml:"when_unsatisfiable"`
+		LabelSelector     *LabelSelector `json:"label_selector,omitempty"     yaml:"label_selector"`
+	}
+
+	// LabelSelector ...
+	LabelSelector struct {
+		MatchLabels      map[string]string     `json:"match_labels,omitempty"      yaml:"match_labels"`
+		MatchExpressions []SelectorRequirement `json:"match_expressions,omitempty" yaml:"match_expressions"`
+	}
+
+	// SelectorRequirement is a requirement of a node or a label selector.
+	SelectorRequirement struct {
+		Key      string   `json:"key,omitempty"`
+		Operator string   `json:"operator,omitempty"`
+		Values   []string `json:"values,omitempty"`
+	}
 )
 
 //
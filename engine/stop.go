@@ -0,0 +1,208 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine/launcher"
+
+	"github.com/drone/runner-go/logger"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/transport/spdy"
+)
+
+const (
+	// stopGracePeriod is the time a step's processes have to exit after receiving SIGTERM.
+	stopGracePeriod = 10 * time.Second
+
+	// stopKillPeriod is the time to wait for the container to terminate after its processes receive SIGKILL.
+	stopKillPeriod = 5 * time.Second
+
+	// execTimeout is the max duration of a command executed in a container.
+	execTimeout = 10 * time.Second
+)
+
+// stopContainer stops the container of a step that is still running. First, the step's processes
+// receive SIGTERM, and SIGKILL after the grace period. The signals are sent by a command executed
+// in the container, which requires a shell in the step's image. If the container is still running
+// after that, it is stopped by reverting its image to the placeholder, the same way it's been started.
+// The function returns after the container is terminated or the pipeline is stopped.
+func (k *Kubernetes) stopContainer(spec *Spec, step *Step, terminated <-chan struct{}, log logger.Logger) {
+	defer k.deleteStepSecret(spec, step, log)
+
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		select {
+		case <-terminated:
+			return true
+		case <-spec.stop:
+			return true
+		case <-timer.C:
+			return false
+		}
+	}
+
+	for _, s := range []struct {
+		signal string
+		wait   time.Duration
+	}{
+		{signal: "TERM", wait: stopGracePeriod},
+		{signal: "KILL", wait: stopKillPeriod},
+	} {
+		if err := k.signalContainer(spec, step, s.signal); err != nil {
+			log.WithError(err).WithField("signal", s.signal).Debug("Engine: Failed to signal step processes")
+			break
+		}
+
+		log.WithField("signal", s.signal).Trace("Engine: Signaled step processes")
+
+		if wait(s.wait) {
+			return
+		}
+	}
+
+	// the image of a native sidecar isn't swapped, it's stopped together with the pod.
+	if isNativeSidecar(spec, step) {
+		return
+	}
+
+	l, ok := k.launchers.Load(spec.PodSpec.Name)
+	if !ok {
+		return
+	}
+
+	if err := <-l.(*launcher.Launcher).Launch(step.ID, step.Placeholder, nil); err != nil && err != launcher.ErrStopped {
+		log.WithError(err).Error("Engine: Failed to stop step container")
+		return
+	}
+
+	log.Trace("Engine: Reverted step container to placeholder")
+}
+
+// signalContainer sends a signal to all processes in a step's container.
+// The PID 1 ignores the signal unless it installed a handler for it, but
+// if the step is a script, the script exits when its commands are killed.
+func (k *Kubernetes) signalContainer(spec *Spec, step *Step, signal string) error {
+	if k.config == nil {
+		return errors.New("kubernetes client configuration is not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+
+	script := fmt.Sprintf("kill -%[1]s 1 2>/dev/null; kill -%[1]s -1 2>/dev/null; exit 0", signal)
+
+	return k.exec(ctx, spec, step.ID, []string{"/bin/sh", "-c", script})
+}
+
+// exec executes a command in a container of the pod.
+func (k *Kubernetes) exec(ctx context.Context, spec *Spec, container string, command []string) error {
+	req := k.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(spec.PodSpec.Name).
+		Namespace(spec.PodSpec.Namespace).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	transport, upgrader, err := spdy.RoundTripperFor(k.config)
+	if err != nil {
+		return err
+	}
+
+	conn := &execConnection{Upgrader: upgrader}
+
+	executor, err := remotecommand.NewSPDYExecutorForTransports(transport, conn, "POST", req.URL())
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+
+	// the executor doesn't support contexts, so its connection is closed
+	// when the context is done, which ends the stream.
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- executor.Stream(remotecommand.StreamOptions{
+			Stdout: io.Discard,
+			Stderr: &stderr,
+		})
+	}()
+
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		go conn.Close()
+		return ctx.Err()
+	}
+
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// execConnection is the upgrader of the exec's SPDY connection, which keeps the connection so
+// that it can be closed. If it's closed before the connection is established, the connection
+// is closed as soon as it's established.
+type execConnection struct {
+	spdy.Upgrader
+
+	mx     sync.Mutex
+	conn   httpstream.Connection
+	closed bool
+}
+
+// NewConnection creates the connection from the upgraded response.
+func (c *execConnection) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := c.Upgrader.NewConnection(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.conn = conn
+	if c.closed {
+		_ = conn.Close()
+	}
+
+	return conn, nil
+}
+
+// Close closes the connection.
+func (c *execConnection) Close() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	c.closed = true
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
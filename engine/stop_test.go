@@ -0,0 +1,100 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine/launcher"
+
+	"github.com/drone/runner-go/logger"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// This test verifies that the step container is reverted to the placeholder
+// image if the signals can't be delivered to the step's processes.
+func TestStopContainer_Placeholder(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "step", Image: "golang"}},
+		},
+	})
+
+	k := New(client, nil, nil, time.Minute, time.Minute, time.Minute, false)
+
+	spec := &Spec{stop: make(chan struct{})}
+	spec.PodSpec.Name = "pod"
+	spec.PodSpec.Namespace = "default"
+
+	step := &Step{ID: "step", Image: "golang", Placeholder: "drone/placeholder:1"}
+
+	l := launcher.New("pod", "default", client)
+	l.Start(context.Background())
+	defer l.Stop()
+	k.launchers.Store("pod", l)
+
+	k.stopContainer(spec, step, make(chan struct{}), logger.Discard())
+
+	pod, err := client.CoreV1().Pods("default").Get(context.Background(), "pod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := pod.Spec.Containers[0].Image, step.Placeholder; got != want {
+		t.Errorf("want container image %s, got %s", want, got)
+	}
+}
+
+type fakeUpgrader struct {
+	conn *fakeConnection
+}
+
+func (u *fakeUpgrader) NewConnection(*http.Response) (httpstream.Connection, error) {
+	return u.conn, nil
+}
+
+type fakeConnection struct {
+	httpstream.Connection
+	closed bool
+}
+
+func (c *fakeConnection) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestExecConnection_Close(t *testing.T) {
+	established := &fakeConnection{}
+	conn := &execConnection{Upgrader: &fakeUpgrader{conn: established}}
+
+	if _, err := conn.NewConnection(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+	if !established.closed {
+		t.Errorf("expected the established connection to be closed")
+	}
+
+	// the connection established after the exec is abandoned is closed right away.
+	late := &fakeConnection{}
+	conn = &execConnection{Upgrader: &fakeUpgrader{conn: late}}
+	conn.Close()
+
+	if _, err := conn.NewConnection(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !late.closed {
+		t.Errorf("expected the connection established after closing to be closed")
+	}
+}
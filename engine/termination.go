@@ -0,0 +1,59 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine/podwatcher"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// describeTermination returns a human-readable diagnostic message for an abnormally terminated
+// step container: killed due to the memory limit, evicted, killed by a signal... For a container
+// that simply exited, with or without an error, the function returns an empty string.
+func describeTermination(step *Step, t podwatcher.Termination) string {
+	var cause string
+
+	switch {
+	case t.OOMKilled():
+		if limit := step.Resources.Limits.Memory; limit > 0 {
+			cause = fmt.Sprintf("killed because it exceeded the memory limit of %s",
+				resource.NewQuantity(limit, resource.BinarySI))
+		} else {
+			cause = "killed because it ran out of memory"
+		}
+	case t.Evicted():
+		cause = "terminated because the pod was evicted from the node"
+	case t.DeadlineExceeded():
+		cause = "terminated because the pod exceeded its active deadline"
+	case t.Signal != 0:
+		cause = fmt.Sprintf("killed by signal %d", t.Signal)
+	case t.Message != "":
+		cause = "terminated"
+	default:
+		return ""
+	}
+
+	sb := &strings.Builder{}
+
+	fmt.Fprintf(sb, "Step %q was %s (exit code %d", step.Name, cause, t.ExitCode)
+	if t.Reason != "" {
+		fmt.Fprintf(sb, ", reason %s", t.Reason)
+	}
+	if !t.StartedAt.IsZero() && !t.FinishedAt.IsZero() {
+		fmt.Fprintf(sb, ", ran for %s", t.FinishedAt.Sub(t.StartedAt).Round(time.Second))
+	}
+	sb.WriteString(")\n")
+
+	if msg := strings.TrimSpace(t.Message); msg != "" {
+		fmt.Fprintf(sb, "Termination message: %s\n", msg)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drone-runners/drone-runner-kube/engine/podwatcher"
+)
+
+func TestDescribeTermination(t *testing.T) {
+	started := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		step        *Step
+		termination podwatcher.Termination
+		want        string
+	}{
+		{
+			name:        "success",
+			step:        &Step{Name: "build"},
+			termination: podwatcher.Termination{ExitCode: 0, Reason: "Completed"},
+			want:        "",
+		},
+		{
+			name:        "failed",
+			step:        &Step{Name: "test"},
+			termination: podwatcher.Termination{ExitCode: 1, Reason: "Error"},
+			want:        "",
+		},
+		{
+			name: "oom killed",
+			step: &Step{Name: "test", Resources: Resources{Limits: ResourceObject{Memory: 512 * 1024 * 1024}}},
+			termination: podwatcher.Termination{
+				ExitCode:   137,
+				Reason:     "OOMKilled",
+				StartedAt:  started,
+				FinishedAt: started.Add(90 * time.Second),
+			},
+			want: "Step \"test\" was killed because it exceeded the memory limit of 512Mi (exit code 137, reason OOMKilled, ran for 1m30s)\n",
+		},
+		{
+			name:        "evicted",
+			step:        &Step{Name: "test"},
+			termination: podwatcher.Termination{ExitCode: 137, Reason: "Error", PodReason: "Evicted", Message: "The node was low on resource: memory."},
+			want: "Step \"test\" was terminated because the pod was evicted from the node (exit code 137, reason Error)\n" +
+				"Termination message: The node was low on resource: memory.\n",
+		},
+		{
+			name:        "signal",
+			step:        &Step{Name: "test"},
+			termination: podwatcher.Termination{ExitCode: 143, Signal: 15},
+			want:        "Step \"test\" was killed by signal 15 (exit code 143)\n",
+		},
+	}
+
+	for _, test := range tests {
+		if got := describeTermination(test.step, test.termination); got != test.want {
+			t.Errorf("test %q: want %q, got %q", test.name, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the engine. The spans are discarded unless
+// a tracer provider is registered with otel.SetTracerProvider.
+var tracer = otel.Tracer("github.com/drone-runners/drone-runner-kube/engine")
+
+// startStageSpan starts a span in the stage's trace.
+func startStageSpan(ctx context.Context, spec *Spec, name string) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() && spec.SpanContext.IsValid() {
+		ctx = trace.ContextWithSpanContext(ctx, spec.SpanContext)
+	}
+
+	return tracer.Start(ctx, name)
+}
+
+// endSpan ends the span and records the error, if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
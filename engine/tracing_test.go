@@ -0,0 +1,62 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestTracing_SetupDestroy(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	// the engine's tracer is replaced instead of the global tracer provider, the
+	// tracers of the global provider can't be detached from it once it's set.
+	defaultTracer := tracer
+	tracer = provider.Tracer("github.com/drone-runners/drone-runner-kube/engine")
+	t.Cleanup(func() { tracer = defaultTracer })
+
+	_, stage := provider.Tracer("test").Start(context.Background(), "Stage")
+
+	k := New(fake.NewSimpleClientset(), nil, nil, time.Minute, time.Minute, time.Minute, false)
+
+	spec := &Spec{SpanContext: stage.SpanContext()}
+	spec.PodSpec.Name = "drone-pod"
+	spec.PodSpec.Namespace = "default"
+
+	// the runner calls Setup and Destroy without the stage's context.
+	if err := k.Setup(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Destroy(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+
+	stage.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("want 3 spans, got %d", len(spans))
+	}
+
+	for i, name := range []string{"Setup", "Destroy"} {
+		span := spans[i]
+		if span.Name() != name {
+			t.Errorf("want span %s, got %s", name, span.Name())
+		}
+		if span.Parent().SpanID() != stage.SpanContext().SpanID() {
+			t.Errorf("want span %s to be a child of the stage span", name)
+		}
+		if span.SpanContext().TraceID() != stage.SpanContext().TraceID() {
+			t.Errorf("want span %s in the stage's trace", name)
+		}
+	}
+}
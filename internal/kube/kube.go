@@ -14,10 +14,10 @@ type ClientConfig struct {
 	Burst int
 }
 
-// New returns a kubernetes client.
+// New returns a kubernetes client and its configuration.
 // It tries first with in-cluster config, if it fails it will try with out-of-cluster config.
-func New(cc *ClientConfig) (client kubernetes.Interface, err error) {
-	client, err = NewInCluster(cc)
+func New(cc *ClientConfig) (client kubernetes.Interface, config *rest.Config, err error) {
+	client, config, err = NewInCluster(cc)
 	if err == nil {
 		return
 	}
@@ -28,7 +28,7 @@ func New(cc *ClientConfig) (client kubernetes.Interface, err error) {
 	}
 	dir = filepath.Join(dir, ".kube", "config")
 
-	client, err = NewFromConfig(cc, dir)
+	client, config, err = NewFromConfig(cc, dir)
 	if err != nil {
 		return
 	}
@@ -36,10 +36,10 @@ func New(cc *ClientConfig) (client kubernetes.Interface, err error) {
 	return
 }
 
-// NewFromConfig returns a new out-of-cluster kubernetes client.
-func NewFromConfig(cc *ClientConfig, path string) (client kubernetes.Interface, err error) {
+// NewFromConfig returns a new out-of-cluster kubernetes client and its configuration.
+func NewFromConfig(cc *ClientConfig, path string) (client kubernetes.Interface, config *rest.Config, err error) {
 	// use the current context in kubeconfig
-	config, err := clientcmd.BuildConfigFromFlags("", path)
+	config, err = clientcmd.BuildConfigFromFlags("", path)
 	if err != nil {
 		return
 	}
@@ -57,10 +57,39 @@ func NewFromConfig(cc *ClientConfig, path string) (client kubernetes.Interface,
 	return
 }
 
-// NewInCluster returns a new in-cluster kubernetes client.
-func NewInCluster(cc *ClientConfig) (client kubernetes.Interface, err error) {
+// NewFromContext returns a new out-of-cluster kubernetes client and its configuration,
+// for a context of the kubeconfig file. If the path is empty, the kubeconfig files are
+// loaded the same way as by kubectl. If the context is empty, the current context is used.
+func NewFromContext(cc *ClientConfig, path, context string) (client kubernetes.Interface, config *rest.Config, err error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if path != "" {
+		rules.ExplicitPath = path
+	}
+
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+
+	config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return
+	}
+
+	cc.apply(config)
+
+	// create the clientset
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return
+	}
+
+	client = clientset
+
+	return
+}
+
+// NewInCluster returns a new in-cluster kubernetes client and its configuration.
+func NewInCluster(cc *ClientConfig) (client kubernetes.Interface, config *rest.Config, err error) {
 	// creates the in-cluster config
-	config, err := rest.InClusterConfig()
+	config, err = rest.InClusterConfig()
 	if err != nil {
 		return
 	}
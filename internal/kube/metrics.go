@@ -0,0 +1,62 @@
+// Copyright 2022 Drone.IO Inc. All rights reserved.
+// Use of this source code is governed by the Polyform License
+// that can be found in the LICENSE file.
+
+package kube
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/client-go/tools/metrics"
+)
+
+var (
+	requestLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "drone_kube_request_duration_seconds",
+		Help:    "Latency of Kubernetes API requests, partitioned by the verb.",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"verb"})
+
+	rateLimiterLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "drone_kube_rate_limiter_duration_seconds",
+		Help:    "Time Kubernetes API requests were delayed by the client side rate limiter, partitioned by the verb.",
+		Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"verb"})
+
+	requestResult = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "drone_kube_requests_total",
+		Help: "Number of Kubernetes API requests, partitioned by the status code and the method.",
+	}, []string{"code", "method"})
+)
+
+// the metrics are registered globally for all clients created by client-go.
+func init() {
+	metrics.Register(metrics.RegisterOpts{
+		RequestLatency:     latencyAdapter{requestLatency},
+		RateLimiterLatency: latencyAdapter{rateLimiterLatency},
+		RequestResult:      resultAdapter{requestResult},
+	})
+}
+
+// latencyAdapter implements client-go's LatencyMetric interface. The URL is not
+// used as a label, because it contains the names of pods and other resources.
+type latencyAdapter struct {
+	m *prometheus.HistogramVec
+}
+
+func (a latencyAdapter) Observe(_ context.Context, verb string, _ url.URL, latency time.Duration) {
+	a.m.WithLabelValues(verb).Observe(latency.Seconds())
+}
+
+// resultAdapter implements client-go's ResultMetric interface.
+type resultAdapter struct {
+	m *prometheus.CounterVec
+}
+
+func (a resultAdapter) Increment(_ context.Context, code, method, _ string) {
+	a.m.WithLabelValues(code, method).Inc()
+}
@@ -4,3 +4,12 @@ set -e
 set -x
 
 docker buildx build --file docker/placeholder/Dockerfile --platform linux/amd64,linux/arm64,linux/arm/v6,linux/arm/v7 -t drone/placeholder:latest --push .
+
+# the windows images have no build steps, buildx builds them on linux from the
+# placeholder binary. The tags are the ones selected for the windows pipelines.
+CGO_ENABLED=0 GOOS=windows GOARCH=amd64 go build -o release/windows/amd64/placeholder.exe ./docker/placeholder
+
+for release in 1809 1903 1909 2004 20H2 ltsc2022; do
+	tag=$(echo $release | tr '[:upper:]' '[:lower:]')
+	docker buildx build --file docker/placeholder/Dockerfile.windows --platform windows/amd64 --build-arg RELEASE=$release -t drone/placeholder:1-windows-$tag-amd64 --push .
+done